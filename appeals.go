@@ -0,0 +1,369 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// appeal statuses
+const (
+	appealPending  = "pending"
+	appealAccepted = "accepted"
+	appealDenied   = "denied"
+)
+
+// Appeal is a request from a candidate (or her assistant) to reconsider an invalid nomination.
+type Appeal struct {
+	ID             int        `json:"id"`
+	NominationID   int        `json:"nomination_id"`
+	CandidateRCS   string     `json:"candidate_rcs"`
+	OfficeID       int        `json:"office_id"`
+	FiledBy        string     `json:"filed_by"`
+	Note           string     `json:"note"`
+	Status         string     `json:"status"`
+	ResolvedBy     *string    `json:"resolved_by"`
+	ResolutionNote *string    `json:"resolution_note"`
+	Created        time.Time  `json:"created"`
+	Resolved       *time.Time `json:"resolved"`
+}
+
+// fileAppeal lets a candidate appeal a nomination that was marked invalid. The body is a JSON object
+// with a note explaining why the nomination should be valid. Only one appeal per nomination can be
+// pending at a time.
+// Authorization is required, and people with permission are admins, the nomination's candidate, and her assistants.
+func fileAppeal(w http.ResponseWriter, r *http.Request) {
+	// extract/validate nomination ID
+	nomID := r.FormValue("nomination")
+	if nomID == "" {
+		writeMissing(w, r, "nomination", "missing nomination ID")
+		return
+	}
+
+	req := struct {
+		Note string `json:"note"`
+	}{}
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&req)
+	if err != nil {
+		log.Printf("unable to decode JSON: %s", err.Error())
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Note) == "" {
+		writeMissing(w, r, "note", "missing note")
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("unable to begin transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// find the nomination's candidate
+	row := tx.QueryRow("SELECT nomination_id, rcs_id, valid FROM nominations WHERE nomination_id = ? AND election_id = "+activeElectionQuery+" FOR UPDATE", nomID)
+	var nominationID int
+	var candidate string
+	var valid *bool
+	err = row.Scan(&nominationID, &candidate, &valid)
+	if err == sql.ErrNoRows {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	candidate = strings.ToLower(candidate)
+
+	// check if this user has permission to do this
+	allowed, err := canActForCandidate(r.Context(), candidate)
+	if err != nil {
+		log.Printf("unable to get candidate assistants: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	if valid == nil || *valid {
+		writeError(w, r, http.StatusConflict, "nomination", "only invalid nominations can be appealed")
+		return
+	}
+	var pending int
+	row = tx.QueryRow("SELECT COUNT(*) FROM appeals WHERE nomination_id = ? AND status = ?", nominationID, appealPending)
+	err = row.Scan(&pending)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if pending > 0 {
+		writeError(w, r, http.StatusConflict, "nomination", "nomination already has a pending appeal")
+		return
+	}
+
+	casUser := casUserFromContext(r.Context())
+	res, err := tx.Exec("INSERT INTO appeals (nomination_id, filed_by, note, status) VALUES (?, ?, ?, ?)", nominationID, casUser, req.Note, appealPending)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	appealID, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("unable to get appeal ID: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	err = recordAudit(r.Context(), tx, auditEntry{
+		Action:       auditFileAppeal,
+		CandidateRCS: candidate,
+		NominationID: nominationID,
+		AppealID:     int(appealID),
+		Details:      req.Note,
+	})
+	if err != nil {
+		log.Printf("unable to record audit entry: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("unable to commit transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	enc.Encode(struct {
+		ID int `json:"id"`
+	}{int(appealID)})
+}
+
+// listAppeals returns appeals in the active election, newest first. With an RCS ID, it only returns
+// that candidate's appeals; otherwise it returns everyone's, which only admins can see.
+// An optional status parameter filters by status.
+// Authorization is required, and people with permission are admins, the candidate with the specified RCS ID, and her assistants.
+func listAppeals(w http.ResponseWriter, r *http.Request) {
+	rcs := strings.ToLower(r.FormValue("rcs"))
+	if rcs == "" {
+		if !adminFromContext(r.Context()) {
+			writeStatus(w, r, http.StatusUnauthorized)
+			return
+		}
+	} else {
+		allowed, err := canActForCandidate(r.Context(), rcs)
+		if err != nil {
+			log.Printf("unable to get candidate assistants: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			writeStatus(w, r, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	query := "SELECT a.appeal_id, a.nomination_id, n.rcs_id, n.office_id, a.filed_by, a.note, a.status, a.resolved_by, a.resolution_note, a.created_at, a.resolved_at FROM appeals a JOIN nominations n ON n.nomination_id = a.nomination_id WHERE n.election_id = " + activeElectionQuery
+	args := []interface{}{}
+	if rcs != "" {
+		query += " AND n.rcs_id = ?"
+		args = append(args, rcs)
+	}
+	if status := r.FormValue("status"); status != "" {
+		query += " AND a.status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY a.appeal_id DESC"
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	appeals := []Appeal{}
+	for rows.Next() {
+		a := Appeal{}
+		err = rows.Scan(&a.ID, &a.NominationID, &a.CandidateRCS, &a.OfficeID, &a.FiledBy, &a.Note, &a.Status, &a.ResolvedBy, &a.ResolutionNote, &a.Created, &a.Resolved)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		appeals = append(appeals, a)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(appeals)
+}
+
+// resolveAppeal accepts or denies a pending appeal. The body is a JSON object with the new status
+// ("accepted" or "denied") and an optional note. Accepting an appeal marks the nomination valid.
+// Requires authorization, and only admins can use it.
+func resolveAppeal(w http.ResponseWriter, r *http.Request) {
+	// check if this user has permission to do this
+	admin := adminFromContext(r.Context())
+	if !admin {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// extract/validate appeal ID
+	appealID := r.FormValue("appeal")
+	if appealID == "" {
+		writeMissing(w, r, "appeal", "missing appeal ID")
+		return
+	}
+
+	req := struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}{}
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&req)
+	if err != nil {
+		log.Printf("unable to decode JSON: %s", err.Error())
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+	if req.Status != appealAccepted && req.Status != appealDenied {
+		writeError(w, r, http.StatusUnprocessableEntity, "status", "status must be accepted or denied")
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("unable to begin transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("SELECT a.appeal_id, a.nomination_id, n.rcs_id, a.status, n.valid, COALESCE(n.election_id = "+activeElectionQuery+", false) FROM appeals a JOIN nominations n ON n.nomination_id = a.nomination_id WHERE a.appeal_id = ? FOR UPDATE", appealID)
+	var id, nominationID int
+	var candidate, status string
+	var valid *bool
+	var active bool
+	err = row.Scan(&id, &nominationID, &candidate, &status, &valid, &active)
+	if err == sql.ErrNoRows {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if status != appealPending {
+		writeError(w, r, http.StatusConflict, "", "appeal already "+status)
+		return
+	}
+	// the nomination may have been revalidated or the election may have moved on since the appeal
+	// was filed, and reinstating it then would be wrong
+	if req.Status == appealAccepted && !active {
+		writeError(w, r, http.StatusConflict, "", "nomination is not in the active election")
+		return
+	}
+	if req.Status == appealAccepted && (valid == nil || *valid) {
+		writeError(w, r, http.StatusConflict, "", "nomination is no longer invalid")
+		return
+	}
+
+	casUser := casUserFromContext(r.Context())
+	_, err = tx.Exec("UPDATE appeals SET status = ?, resolved_by = ?, resolution_note = ?, resolved_at = NOW() WHERE appeal_id = ?", req.Status, casUser, req.Note, id)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	action := auditDenyAppeal
+	if req.Status == appealAccepted {
+		action = auditAcceptAppeal
+		previousCount, err := lockValidCount(tx, nominationID)
+		if err == nil {
+			_, err = tx.Exec("UPDATE nominations SET valid = true WHERE nomination_id = ? AND valid = false", nominationID)
+		}
+		if err == nil {
+			err = resolveReview(tx, nominationID)
+		}
+		if err == nil {
+			err = queueNominationWebhooks(tx, nominationID, true, previousCount)
+		}
+		if err != nil {
+			log.Printf("unable to reinstate nomination: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	details := req.Note
+	if req.Status == appealAccepted {
+		details = fmt.Sprintf("nomination marked valid; %s", req.Note)
+	}
+	err = recordAudit(r.Context(), tx, auditEntry{
+		Action:       action,
+		CandidateRCS: strings.ToLower(candidate),
+		NominationID: nominationID,
+		AppealID:     id,
+		Details:      details,
+	})
+	if err != nil {
+		log.Printf("unable to record audit entry: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("unable to commit transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if req.Status == appealAccepted {
+		publishNomination(db, eventNominationModified, nominationID, true)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileAppeal(t *testing.T) {
+	nominationColumns := []string{"nomination_id", "rcs_id", "valid"}
+	invalid := fakeQuery{match: "SELECT nomination_id, rcs_id, valid FROM nominations", columns: nominationColumns, rows: [][]driver.Value{[]driver.Value{int64(7), "LYONJ4", int64(0)}}}
+	noAssistants := fakeQuery{match: "FROM assistants", columns: []string{"rcs_id"}, rows: [][]driver.Value{}}
+
+	type testCase struct {
+		expected int
+		ctx      context.Context
+		target   string
+		body     string
+		queries  []fakeQuery
+		// inserted is whether an appeal should have been stored
+		inserted bool
+	}
+	cases := []testCase{
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("lyonj4", false), target: "/appeals", body: `{"note": "she is a junior"}`},
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("lyonj4", false), target: "/appeals?nomination=7", body: `{"note": " "}`},
+		testCase{expected: http.StatusBadRequest, ctx: userContext("lyonj4", false), target: "/appeals?nomination=7", body: `{`},
+		testCase{
+			expected: http.StatusNotFound, ctx: userContext("lyonj4", false), target: "/appeals?nomination=7", body: `{"note": "she is a junior"}`,
+			queries: []fakeQuery{fakeQuery{match: "SELECT nomination_id, rcs_id, valid FROM nominations", columns: nominationColumns, rows: [][]driver.Value{}}},
+		},
+		// someone else's nomination
+		testCase{
+			expected: http.StatusUnauthorized, ctx: userContext("smithj", false), target: "/appeals?nomination=7", body: `{"note": "she is a junior"}`,
+			queries: []fakeQuery{invalid, noAssistants},
+		},
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("lyonj4", false), target: "/appeals?nomination=7", body: `{"note": "she is a junior"}`,
+			queries: []fakeQuery{fakeQuery{match: "SELECT nomination_id, rcs_id, valid FROM nominations", columns: nominationColumns, rows: [][]driver.Value{[]driver.Value{int64(7), "lyonj4", nil}}}},
+		},
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("lyonj4", false), target: "/appeals?nomination=7", body: `{"note": "she is a junior"}`,
+			queries: []fakeQuery{fakeQuery{match: "SELECT nomination_id, rcs_id, valid FROM nominations", columns: nominationColumns, rows: [][]driver.Value{[]driver.Value{int64(7), "lyonj4", int64(1)}}}},
+		},
+		// already has a pending appeal
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("lyonj4", false), target: "/appeals?nomination=7", body: `{"note": "she is a junior"}`,
+			queries: []fakeQuery{invalid, fakeQuery{match: "SELECT COUNT(*) FROM appeals", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(1)}}}},
+		},
+		testCase{
+			expected: http.StatusCreated, ctx: userContext("lyonj4", false), target: "/appeals?nomination=7", body: `{"note": "she is a junior"}`,
+			queries: []fakeQuery{
+				invalid,
+				fakeQuery{match: "SELECT COUNT(*) FROM appeals", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(0)}}},
+				fakeQuery{match: "INSERT INTO appeals", affected: 1, insertID: 3},
+				fakeQuery{match: "INSERT INTO audit_log", affected: 1},
+			},
+			inserted: true,
+		},
+		// assistants can appeal for their candidate
+		testCase{
+			expected: http.StatusCreated, ctx: userContext("smithj", false), target: "/appeals?nomination=7", body: `{"note": "she is a junior"}`,
+			queries: []fakeQuery{
+				invalid,
+				fakeQuery{match: "FROM assistants", columns: []string{"rcs_id"}, rows: [][]driver.Value{[]driver.Value{"SMITHJ"}}},
+				fakeQuery{match: "SELECT COUNT(*) FROM appeals", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(0)}}},
+				fakeQuery{match: "INSERT INTO appeals", affected: 1, insertID: 3},
+				fakeQuery{match: "INSERT INTO audit_log", affected: 1},
+			},
+			inserted: true,
+		},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		fileAppeal(w, httptest.NewRequest(http.MethodPost, c.target, strings.NewReader(c.body)).WithContext(c.ctx))
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s %s: expected status %d, got %d: %s", c.target, c.body, c.expected, w.Code, w.Body.String())
+		}
+		if inserted := len(db.called("INSERT INTO appeals")) == 1; inserted != c.inserted {
+			t.Errorf("%s %s: expected inserted to be %v", c.target, c.body, c.inserted)
+		}
+	}
+}
+
+func TestResolveAppeal(t *testing.T) {
+	appealColumns := []string{"appeal_id", "nomination_id", "rcs_id", "status", "valid", "active"}
+	appeal := func(status string, valid driver.Value, active int64) fakeQuery {
+		return fakeQuery{match: "FROM appeals a JOIN nominations n", columns: appealColumns, rows: [][]driver.Value{[]driver.Value{int64(3), int64(7), "lyonj4", status, valid, active}}}
+	}
+	resolved := []fakeQuery{
+		fakeQuery{match: "UPDATE appeals SET status", affected: 1},
+		fakeQuery{match: "INSERT INTO audit_log", affected: 1},
+	}
+
+	type testCase struct {
+		expected int
+		ctx      context.Context
+		target   string
+		body     string
+		queries  []fakeQuery
+		// reinstated is whether the nomination should have been marked valid
+		reinstated bool
+	}
+	cases := []testCase{
+		testCase{expected: http.StatusUnauthorized, ctx: userContext("lyonj4", false), target: "/appeals?appeal=3", body: `{"status": "accepted"}`},
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("admin1", true), target: "/appeals", body: `{"status": "accepted"}`},
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "pending"}`},
+		testCase{
+			expected: http.StatusNotFound, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "accepted"}`,
+			queries: []fakeQuery{fakeQuery{match: "FROM appeals a JOIN nominations n", columns: appealColumns, rows: [][]driver.Value{}}},
+		},
+		testCase{
+			expected: http.StatusNoContent, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "denied", "note": "no"}`,
+			queries: append([]fakeQuery{appeal(appealPending, int64(0), 1)}, resolved...),
+		},
+		testCase{
+			expected: http.StatusNoContent, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "accepted"}`,
+			queries: append(append([]fakeQuery{
+				appeal(appealPending, int64(0), 1),
+				fakeQuery{match: "SUM(n.valid = true)", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(0)}}},
+				fakeQuery{match: "UPDATE nominations SET valid = true", affected: 1},
+				fakeQuery{match: "UPDATE nomination_reviews SET flagged = false", affected: 1},
+				// webhooks are queued in the transaction, and the event is published after it
+				fakeQuery{match: "SELECT rcs_id, office_id, page, valid FROM nominations", columns: []string{"rcs_id", "office_id", "page", "valid"}, rows: [][]driver.Value{[]driver.Value{"lyonj4", int64(3), int64(1), int64(1)}}},
+				fakeQuery{match: "SELECT COUNT(*) FROM nominations", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(1)}}},
+				fakeQuery{match: "SUM(n.valid = true)", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(1)}}},
+				fakeQuery{match: "SELECT nominations_required FROM offices", columns: []string{"nominations_required"}, rows: [][]driver.Value{[]driver.Value{int64(50)}}},
+				fakeQuery{match: "INSERT INTO webhook_deliveries", affected: 1},
+			}, resolved...),
+				fakeQuery{match: "SELECT rcs_id, office_id, page, valid FROM nominations", columns: []string{"rcs_id", "office_id", "page", "valid"}, rows: [][]driver.Value{[]driver.Value{"lyonj4", int64(3), int64(1), int64(1)}}},
+				fakeQuery{match: "SELECT COUNT(*) FROM nominations", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(1)}}},
+			),
+			reinstated: true,
+		},
+		// appeals can only be resolved once
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "accepted"}`,
+			queries: []fakeQuery{appeal(appealDenied, int64(0), 1)},
+		},
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "denied"}`,
+			queries: []fakeQuery{appeal(appealAccepted, int64(1), 1)},
+		},
+		// the nomination was revalidated since the appeal was filed
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "accepted"}`,
+			queries: []fakeQuery{appeal(appealPending, int64(1), 1)},
+		},
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "accepted"}`,
+			queries: []fakeQuery{appeal(appealPending, nil, 1)},
+		},
+		// the nomination is from an old election
+		testCase{
+			expected: http.StatusConflict, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "accepted"}`,
+			queries: []fakeQuery{appeal(appealPending, int64(0), 0)},
+		},
+		// denying doesn't depend on the nomination
+		testCase{
+			expected: http.StatusNoContent, ctx: userContext("admin1", true), target: "/appeals?appeal=3", body: `{"status": "denied"}`,
+			queries: append([]fakeQuery{appeal(appealPending, int64(1), 0)}, resolved...),
+		},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		resolveAppeal(w, httptest.NewRequest(http.MethodPut, c.target, strings.NewReader(c.body)).WithContext(c.ctx))
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s %s: expected status %d, got %d: %s", c.target, c.body, c.expected, w.Code, w.Body.String())
+		}
+		if reinstated := len(db.called("UPDATE nominations SET valid = true")) == 1; reinstated != c.reinstated {
+			t.Errorf("%s %s: expected reinstated to be %v", c.target, c.body, c.reinstated)
+		}
+		// a reinstated nomination leaves the review queue
+		if resolved := len(db.called("UPDATE nomination_reviews SET flagged = false")) == 1; resolved != c.reinstated {
+			t.Errorf("%s %s: expected the review to be resolved only if reinstated", c.target, c.body)
+		}
+	}
+}
+
+func TestListAppeals(t *testing.T) {
+	created := time.Date(2019, time.February, 1, 12, 0, 0, 0, time.UTC)
+	appealColumns := []string{"appeal_id", "nomination_id", "rcs_id", "office_id", "filed_by", "note", "status", "resolved_by", "resolution_note", "created_at", "resolved_at"}
+	appeals := fakeQuery{match: "FROM appeals a JOIN nominations n", columns: appealColumns, rows: [][]driver.Value{
+		[]driver.Value{int64(3), int64(7), "lyonj4", int64(2), "lyonj4", "she is a junior", appealPending, nil, nil, created, nil},
+	}}
+
+	type testCase struct {
+		expected int
+		ctx      context.Context
+		target   string
+		queries  []fakeQuery
+	}
+	cases := []testCase{
+		// only admins can see everyone's appeals
+		testCase{expected: http.StatusUnauthorized, ctx: userContext("lyonj4", false), target: "/appeals"},
+		testCase{
+			expected: http.StatusUnauthorized, ctx: userContext("smithj", false), target: "/appeals?rcs=lyonj4",
+			queries: []fakeQuery{fakeQuery{match: "FROM assistants", columns: []string{"rcs_id"}, rows: [][]driver.Value{}}},
+		},
+		testCase{expected: http.StatusOK, ctx: userContext("lyonj4", false), target: "/appeals?rcs=LyonJ4", queries: []fakeQuery{appeals}},
+		testCase{expected: http.StatusOK, ctx: userContext("admin1", true), target: "/appeals?status=pending", queries: []fakeQuery{appeals}},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		listAppeals(w, httptest.NewRequest(http.MethodGet, c.target, nil).WithContext(c.ctx))
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expected, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		result := []Appeal{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 1 || result[0].ID != 3 || result[0].Status != appealPending || result[0].Resolved != nil {
+			t.Errorf("%s: unexpected appeals %+v", c.target, result)
+		}
+		calls := db.called("FROM appeals a JOIN nominations n")
+		if len(calls) != 1 || (strings.Contains(c.target, "rcs=") && calls[0].args[0] != "lyonj4") {
+			t.Errorf("%s: unexpected query %+v", c.target, calls)
+		}
+	}
+}
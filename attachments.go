@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAttachmentBytes limits the size of an uploaded page scan.
+const maxAttachmentBytes = 10 << 20
+
+// attachmentTypes are the content types page scans may have.
+var attachmentTypes = []string{"application/pdf", "image/png", "image/jpeg"}
+
+// attachmentExtensions are the extensions given to scans uploaded without a filename.
+var attachmentExtensions = map[string]string{"application/pdf": ".pdf", "image/png": ".png", "image/jpeg": ".jpg"}
+
+// Attachment describes the scan of a nomination page.
+type Attachment struct {
+	CandidateRCS string    `json:"candidate_rcs"`
+	OfficeID     int       `json:"office_id"`
+	Page         int       `json:"page_number"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Filename     string    `json:"filename"`
+	UploadedBy   string    `json:"uploaded_by"`
+	Uploaded     time.Time `json:"uploaded"`
+}
+
+// attachmentType sniffs the content type of a file from its first bytes, returning false if it
+// isn't an allowed type. The type the client claims isn't trusted.
+func attachmentType(head []byte) (string, bool) {
+	contentType := http.DetectContentType(head)
+	for _, allowed := range attachmentTypes {
+		if contentType == allowed {
+			return contentType, true
+		}
+	}
+	return contentType, false
+}
+
+// limitedBody is a request body limited by http.MaxBytesReader, which counts what has been read so
+// a failed read can be told apart from one that failed because the body was too large.
+type limitedBody struct {
+	io.ReadCloser
+	read  int64
+	limit int64
+}
+
+func newLimitedBody(w http.ResponseWriter, body io.ReadCloser, limit int64) *limitedBody {
+	return &limitedBody{ReadCloser: http.MaxBytesReader(w, body, limit), limit: limit}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// exceeded returns whether the whole limit has been read, so any error reading was from the limit.
+func (b *limitedBody) exceeded() bool {
+	return b.read >= b.limit
+}
+
+// attachmentPage extracts and checks the candidate RCS ID, office ID and page number that identify
+// a page, and that the user may act for the candidate. If it returns false, a response has been written.
+// They only come from the query string: FormValue would parse (and buffer) an upload's multipart body,
+// leaving nothing for uploadAttachment to stream.
+func attachmentPage(w http.ResponseWriter, r *http.Request) (string, int, int, bool) {
+	query := r.URL.Query()
+	rcs := strings.ToLower(query.Get("rcs"))
+	if rcs == "" {
+		writeMissing(w, r, "rcs", "missing rcs")
+		return "", 0, 0, false
+	}
+	if query.Get("office") == "" {
+		writeMissing(w, r, "office", "missing office")
+		return "", 0, 0, false
+	}
+	office, err := strconv.Atoi(query.Get("office"))
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "office", "invalid office")
+		return "", 0, 0, false
+	}
+	if query.Get("page") == "" {
+		writeMissing(w, r, "page", "missing page")
+		return "", 0, 0, false
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, "page", "invalid page")
+		return "", 0, 0, false
+	}
+
+	// check if this user has permission to do this
+	allowed, err := canActForCandidate(r.Context(), rcs)
+	if err != nil {
+		log.Printf("unable to get candidate assistants: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return "", 0, 0, false
+	}
+	if !allowed {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return "", 0, 0, false
+	}
+	return rcs, office, page, true
+}
+
+// uploadAttachment stores a scan of a signed nomination page, sent as the "file" field of a
+// multipart form. It must be a PDF, PNG or JPEG, no larger than maxAttachmentBytes.
+// Uploading a scan for a page that already has one replaces it.
+// Authorization is required, and people with permission are admins, the candidate with the specified RCS ID, and her assistants.
+func uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	rcs, office, page, ok := attachmentPage(w, r)
+	if !ok {
+		return
+	}
+
+	// find the file in the form, without buffering the whole upload
+	body := newLimitedBody(w, r.Body, maxAttachmentBytes+1<<20)
+	r.Body = body
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "", "expected multipart form")
+		return
+	}
+	var filename string
+	var file io.Reader
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil && body.exceeded() {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "file", "file too large")
+			return
+		} else if err != nil {
+			writeStatus(w, r, http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			filename = part.FileName()
+			file = part
+			break
+		}
+	}
+	if file == nil {
+		writeMissing(w, r, "file", "missing file")
+		return
+	}
+
+	// check the type from the first bytes of the file
+	buffered := bufio.NewReaderSize(file, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+	contentType, ok := attachmentType(head)
+	if !ok {
+		writeError(w, r, http.StatusUnsupportedMediaType, "file", "file must be a PDF, PNG or JPEG")
+		return
+	}
+
+	// only keep the name of the file, without any directories, or name it after the page
+	if filename != "" {
+		filename = filepath.Base(filename)
+	}
+	if filename == "" || filename == "." || filename == "/" {
+		filename = fmt.Sprintf("page-%d%s", page, attachmentExtensions[contentType])
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	// the page has to exist
+	var count int
+	row := db.QueryRow("SELECT COUNT(*) FROM nominations WHERE rcs_id = ? AND office_id = ? AND page = ? AND election_id = "+activeElectionQuery, rcs, office, page)
+	err = row.Scan(&count)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+
+	// store the file, refusing anything too big
+	store, err := getBlobStore()
+	if err != nil {
+		log.Printf("unable to get blob store: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	key, err := newBlobKey()
+	if err != nil {
+		log.Printf("unable to generate blob key: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	size, err := store.Put(key, io.LimitReader(buffered, maxAttachmentBytes+1))
+	if err != nil && body.exceeded() {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "file", "file too large")
+		return
+	} else if err != nil {
+		log.Printf("unable to store attachment: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if size > maxAttachmentBytes {
+		store.Delete(key)
+		writeError(w, r, http.StatusRequestEntityTooLarge, "file", "file too large")
+		return
+	}
+
+	// link it to the page, replacing any previous scan
+	tx, err := db.Begin()
+	if err != nil {
+		store.Delete(key)
+		log.Printf("unable to begin transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var oldKey sql.NullString
+	row = tx.QueryRow("SELECT blob_key FROM page_attachments WHERE candidate_rcs_id = ? AND office_id = ? AND page = ? AND election_id = "+activeElectionQuery+" FOR UPDATE", rcs, office, page)
+	err = row.Scan(&oldKey)
+	if err != nil && err != sql.ErrNoRows {
+		store.Delete(key)
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	casUser := casUserFromContext(r.Context())
+	_, err = tx.Exec("INSERT INTO page_attachments (candidate_rcs_id, office_id, page, election_id, blob_key, content_type, size, filename, uploaded_by) VALUES (?, ?, ?, "+activeElectionQuery+", ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE blob_key = VALUES(blob_key), content_type = VALUES(content_type), size = VALUES(size), filename = VALUES(filename), uploaded_by = VALUES(uploaded_by), uploaded_at = NOW()", rcs, office, page, key, contentType, size, filename, casUser)
+	if err == nil {
+		err = recordAudit(r.Context(), tx, auditEntry{Action: auditAttachPage, CandidateRCS: rcs, Details: fmt.Sprintf("office %d page %d: %s (%d bytes)", office, page, filename, size)})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		store.Delete(key)
+		log.Printf("unable to save attachment: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if oldKey.Valid {
+		err = store.Delete(oldKey.String)
+		if err != nil {
+			log.Printf("unable to delete replaced attachment %s: %s", oldKey.String, err.Error())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	enc.Encode(Attachment{
+		CandidateRCS: rcs,
+		OfficeID:     office,
+		Page:         page,
+		ContentType:  contentType,
+		Size:         size,
+		Filename:     filename,
+		UploadedBy:   casUser,
+		Uploaded:     time.Now(),
+	})
+}
+
+// downloadAttachment returns the scan of a nomination page.
+// Authorization is required, and people with permission are admins, the candidate with the specified RCS ID, and her assistants.
+func downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	rcs, office, page, ok := attachmentPage(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	row := db.QueryRow("SELECT blob_key, content_type, size, filename FROM page_attachments WHERE candidate_rcs_id = ? AND office_id = ? AND page = ? AND election_id = "+activeElectionQuery, rcs, office, page)
+	var key, contentType, filename string
+	var size int64
+	err = row.Scan(&key, &contentType, &size, &filename)
+	if err == sql.ErrNoRows {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		log.Printf("unable to get blob store: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	blob, err := store.Get(key)
+	if err != nil {
+		log.Printf("unable to get attachment %s: %s", key, err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	disposition := mime.FormatMediaType("inline", map[string]string{"filename": filename})
+	if disposition == "" {
+		// the filename can't be encoded, so leave it to the browser
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		log.Printf("unable to send attachment %s: %s", key, err.Error())
+	}
+}
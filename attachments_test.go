@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// multipartBody builds a multipart form with the given file in its "file" field, if any, after
+// a comment field so the handler has to skip over it.
+func multipartBody(t *testing.T, comment string, field string, filename string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	err := mw.WriteField("comment", comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field != "" {
+		part, err := mw.CreateFormFile(field, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, mw.FormDataContentType()
+}
+
+func TestUploadAttachment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elecnoms-attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("ATTACHMENT_DIR", os.Getenv("ATTACHMENT_DIR"))
+	os.Setenv("ATTACHMENT_DIR", dir)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1000)...)
+	pageExists := fakeQuery{match: "SELECT COUNT(*) FROM nominations", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(1)}}}
+	stored := []fakeQuery{
+		pageExists,
+		fakeQuery{match: "SELECT blob_key FROM page_attachments", columns: []string{"blob_key"}, rows: [][]driver.Value{}},
+		fakeQuery{match: "INSERT INTO page_attachments", affected: 1},
+		fakeQuery{match: "INSERT INTO audit_log", affected: 1},
+	}
+
+	type testCase struct {
+		expected int
+		ctx      context.Context
+		target   string
+		comment  string
+		field    string
+		name     string
+		content  []byte
+		queries  []fakeQuery
+		// filename is the name the scan is expected to be stored under
+		filename string
+	}
+	cases := []testCase{
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("lyonj4", false), target: "/attachments?office=3&page=1", field: "file", content: png},
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3", field: "file", content: png},
+		testCase{
+			expected: http.StatusUnauthorized, ctx: userContext("smithj", false), target: "/attachments?rcs=lyonj4&office=3&page=1", field: "file", content: png,
+			queries: []fakeQuery{fakeQuery{match: "FROM assistants", columns: []string{"rcs_id"}, rows: [][]driver.Value{}}},
+		},
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3&page=1"},
+		testCase{expected: http.StatusUnsupportedMediaType, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3&page=1", field: "file", content: []byte("just some text")},
+		testCase{
+			expected: http.StatusNotFound, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3&page=1", field: "file", content: png,
+			queries: []fakeQuery{fakeQuery{match: "SELECT COUNT(*) FROM nominations", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(0)}}}},
+		},
+		testCase{
+			expected: http.StatusRequestEntityTooLarge, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3&page=1", field: "file",
+			content: append(append([]byte{}, png...), bytes.Repeat([]byte{0}, maxAttachmentBytes)...), queries: []fakeQuery{pageExists},
+		},
+		// the body is cut off past the limit, while the file is being stored or before it
+		testCase{
+			expected: http.StatusRequestEntityTooLarge, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3&page=1",
+			comment: strings.Repeat("x", 1<<20), field: "file", content: append(append([]byte{}, png...), bytes.Repeat([]byte{0}, maxAttachmentBytes-len(png))...), queries: []fakeQuery{pageExists},
+		},
+		testCase{
+			expected: http.StatusRequestEntityTooLarge, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3&page=1",
+			comment: strings.Repeat("x", maxAttachmentBytes+2<<20), field: "file", content: png,
+		},
+		testCase{expected: http.StatusCreated, ctx: userContext("lyonj4", false), target: "/attachments?rcs=LyonJ4&office=3&page=1", field: "file", name: "scan.png", content: png, queries: stored, filename: "scan.png"},
+		// scans uploaded without a name are named after their page
+		testCase{expected: http.StatusCreated, ctx: userContext("lyonj4", false), target: "/attachments?rcs=lyonj4&office=3&page=1", field: "file", content: png, queries: stored, filename: "page-1.png"},
+	}
+
+	for _, c := range cases {
+		body, contentType := multipartBody(t, c.comment, c.field, c.name, c.content)
+		req := httptest.NewRequest(http.MethodPost, c.target, body).WithContext(c.ctx)
+		req.Header.Set("Content-Type", contentType)
+
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		uploadAttachment(w, req)
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expected, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusCreated {
+			continue
+		}
+
+		attachment := Attachment{}
+		err = json.NewDecoder(w.Body).Decode(&attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attachment.ContentType != "image/png" || attachment.Size != int64(len(png)) || attachment.Filename != c.filename || attachment.CandidateRCS != "lyonj4" {
+			t.Errorf("%s: unexpected attachment %+v", c.target, attachment)
+		}
+
+		// the file was streamed into the store under the key that was saved
+		calls := db.called("INSERT INTO page_attachments")
+		if len(calls) != 1 {
+			t.Fatalf("%s: expected one insert, got %+v", c.target, calls)
+		}
+		key, _ := calls[0].args[3].(string)
+		content, err := ioutil.ReadFile(filepath.Join(dir, key))
+		if err != nil || !bytes.Equal(content, png) {
+			t.Errorf("%s: stored file %q doesn't match the upload: %v", c.target, key, err)
+		}
+	}
+}
+
+func TestDownloadAttachment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elecnoms-attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("ATTACHMENT_DIR", os.Getenv("ATTACHMENT_DIR"))
+	os.Setenv("ATTACHMENT_DIR", dir)
+
+	key := "0123456789abcdef0123456789abcdef"
+	content := []byte("%PDF-1.4 page one")
+	err = ioutil.WriteFile(filepath.Join(dir, key), content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		filename    string
+		disposition string
+	}
+	cases := []testCase{
+		testCase{filename: "scan.pdf", disposition: `inline; filename=scan.pdf`},
+		testCase{filename: `page "one".pdf`, disposition: `inline; filename="page \"one\".pdf"`},
+		testCase{filename: "página.pdf", disposition: `inline; filename*=utf-8''p%C3%A1gina.pdf`},
+	}
+
+	for _, c := range cases {
+		_, done := useFakeDB(t, fakeQuery{
+			match:   "FROM page_attachments",
+			columns: []string{"blob_key", "content_type", "size", "filename"},
+			rows:    [][]driver.Value{[]driver.Value{key, "application/pdf", int64(len(content)), c.filename}},
+		})
+		w := httptest.NewRecorder()
+		downloadAttachment(w, httptest.NewRequest(http.MethodGet, "/attachments?rcs=lyonj4&office=3&page=1", nil).WithContext(userContext("lyonj4", false)))
+		done()
+
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+			t.Errorf("%s: unexpected response %d: %s", c.filename, w.Code, w.Body.String())
+		}
+		if disposition := w.Header().Get("Content-Disposition"); disposition != c.disposition {
+			t.Errorf("%s: expected Content-Disposition %q, got %q", c.filename, c.disposition, disposition)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so audit records can be written as part of a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// auditEntry describes a change to be recorded in the audit log.
+// CandidateRCS, NominationID and AppealID may be left empty if they don't apply.
+type auditEntry struct {
+	Action       string
+	CandidateRCS string
+	NominationID int
+	AppealID     int
+	Details      string
+}
+
+// audit actions
+const (
+	auditSubmitPage       = "page.submit"
+	auditModifyNomination = "nomination.modify"
+	auditFileAppeal       = "appeal.file"
+	auditAcceptAppeal     = "appeal.accept"
+	auditDenyAppeal       = "appeal.deny"
+	auditAttachPage       = "page.attach"
+)
+
+// recordAudit writes an entry to the audit log, attributed to the user on the context. If an admin
+// is impersonating someone, both are recorded and the entry is marked as impersonated.
+func recordAudit(ctx context.Context, ex execer, entry auditEntry) error {
+	actor := realUserFromContext(ctx)
+	effective := casUserFromContext(ctx)
+
+	candidate := sql.NullString{String: entry.CandidateRCS, Valid: entry.CandidateRCS != ""}
+	nominationID := sql.NullInt64{Int64: int64(entry.NominationID), Valid: entry.NominationID != 0}
+	appealID := sql.NullInt64{Int64: int64(entry.AppealID), Valid: entry.AppealID != 0}
+
+	_, err := ex.Exec("INSERT INTO audit_log (actor_rcs_id, effective_rcs_id, impersonated, action, candidate_rcs_id, nomination_id, appeal_id, details) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", actor, effective, impersonatingFromContext(ctx), entry.Action, candidate, nominationID, appealID, entry.Details)
+	return err
+}
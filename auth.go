@@ -4,8 +4,9 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
@@ -29,28 +30,18 @@ type contextKey string
 const casUserKey = contextKey("casUser")
 const adminKey = contextKey("admin")
 const authenticatedKey = contextKey("authenticated")
+const realUserKey = contextKey("realUser")
 
-// Take cookie, extract session ID, decode additional info from database,
+// Take cookie, extract session ID, decode additional info from database (or the session cache),
 // and attach to context. Assumes that cookie has been validated already.
 func contextFromCookie(ctx context.Context, cookie *http.Cookie) (context.Context, error) {
 	// extract stuff from cookie
-	messageSplit := strings.Split(cookie.Value, ".")
-	sessionID := []byte(messageSplit[0][4:])
-
-	db, err := getDB()
+	sessionID, _, err := splitCookie(cookie)
 	if err != nil {
 		return ctx, err
 	}
-	defer db.Close()
 
-	row := db.QueryRow("SELECT data FROM sessions WHERE session_id = ?", sessionID)
-	var jsonData []byte
-	err = row.Scan(&jsonData)
-	if err != nil {
-		return ctx, err
-	}
-	sd := sessionData{}
-	err = json.Unmarshal(jsonData, &sd)
+	sd, err := loadSession(sessionID)
 	if err != nil {
 		return ctx, err
 	}
@@ -85,29 +76,107 @@ func casUserFromContext(ctx context.Context) string {
 	return casUser
 }
 
+// realUserFromContext returns who is really making the request. This is the same as the CAS user
+// unless an admin is impersonating someone.
+func realUserFromContext(ctx context.Context) string {
+	realUser, ok := ctx.Value(realUserKey).(string)
+	if !ok {
+		return casUserFromContext(ctx)
+	}
+	return realUser
+}
+func impersonatingFromContext(ctx context.Context) bool {
+	_, ok := ctx.Value(realUserKey).(string)
+	return ok
+}
+
+// impersonate makes the request act as another user, without admin rights, while remembering
+// the admin who is really making it.
+func impersonate(ctx context.Context, rcs string) context.Context {
+	ctx = context.WithValue(ctx, realUserKey, casUserFromContext(ctx))
+	ctx = context.WithValue(ctx, casUserKey, strings.ToLower(rcs))
+	ctx = context.WithValue(ctx, adminKey, false)
+	return ctx
+}
+
+// errMalformedCookie is returned when a session cookie isn't in the format express-session signs.
+var errMalformedCookie = errors.New("malformed session cookie")
+
+// signedCookiePrefix is the URL-encoded "s:" that node-cookie-signature prepends to signed values.
+const signedCookiePrefix = "s%3A"
+
+// splitCookie separates a signed session cookie into its session ID and URL-encoded MAC.
+func splitCookie(cookie *http.Cookie) (string, string, error) {
+	if !strings.HasPrefix(cookie.Value, signedCookiePrefix) {
+		return "", "", errMalformedCookie
+	}
+	messageSplit := strings.Split(cookie.Value[len(signedCookiePrefix):], ".")
+	if len(messageSplit) != 2 {
+		return "", "", errMalformedCookie
+	}
+	return messageSplit[0], messageSplit[1], nil
+}
+
+// sessionSecrets returns the secrets that session cookies may be signed with. Like the secret
+// option of express-session, SESSION_SECRET may be a comma-separated list: the first secret is
+// the one elections currently signs with, and the rest are older secrets that are still accepted
+// so the shared secret can be rotated without logging everyone out.
+func sessionSecrets() []string {
+	secrets := []string{}
+	for _, secret := range strings.Split(os.Getenv("SESSION_SECRET"), ",") {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// cookieMAC returns the base64-encoded HMAC of message, as computed by node-cookie-signature.
+func cookieMAC(message string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signCookie signs a session ID the way express-session does, using the first (current) session secret,
+// and returns the cookie value.
+func signCookie(sessionID string) (string, error) {
+	secrets := sessionSecrets()
+	if len(secrets) == 0 {
+		return "", errors.New("SESSION_SECRET not set")
+	}
+	mac := cookieMAC(sessionID, secrets[0])
+	return signedCookiePrefix + sessionID + "." + url.QueryEscape(mac), nil
+}
+
 // verifyCookie returns whether or not a cookie signed with https://github.com/tj/node-cookie-signature
-// has been modified, tampered with, or otherwise mangled.
+// has been modified, tampered with, or otherwise mangled. The cookie is accepted if it was signed
+// with any of the configured session secrets.
 func verifyCookie(cookie *http.Cookie) (bool, error) {
 	// extract stuff from cookie
-	messageSplit := strings.Split(cookie.Value, ".")
-	message := []byte(messageSplit[0][4:])
-	messageMAC := messageSplit[1]
-
-	// create HMAC to see if it matches the one in the cookie
-	mac := hmac.New(sha256.New, []byte(os.Getenv("SESSION_SECRET")))
-	mac.Write([]byte(message))
-	expectedMAC := mac.Sum(nil)
-	expectedMACEncoded := base64.RawStdEncoding.EncodeToString(expectedMAC)
+	message, messageMAC, err := splitCookie(cookie)
+	if err != nil {
+		return false, err
+	}
 	messageMACUnescaped, err := url.QueryUnescape(messageMAC)
 	if err != nil {
 		return false, err
 	}
 
-	return messageMACUnescaped == expectedMACEncoded, nil
+	// create HMAC with each secret to see if one matches the one in the cookie
+	for _, secret := range sessionSecrets() {
+		expectedMAC := cookieMAC(message, secret)
+		if hmac.Equal([]byte(messageMACUnescaped), []byte(expectedMAC)) {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 // authenticate decodes a session cookie from https://github.com/expressjs/session,
 // extracts the session info from the database, and stores it on the request context.
+// Requests with an API token in their Authorization header are authenticated with that instead.
+// Admins logged in with a session can set the X-Impersonate header to an RCS ID to act as that user.
 func authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -120,6 +189,20 @@ func authenticate(next http.Handler) http.Handler {
 		origCtx := r.Context()
 		r = r.WithContext(unauthenticatedContext(origCtx))
 
+		// scripts and other services authenticate with an API token instead of a cookie
+		if token := bearerToken(r); token != "" {
+			ctx, err := contextFromToken(origCtx, token)
+			if err == sql.ErrNoRows {
+				log.Printf("API token invalid")
+				return
+			} else if err != nil {
+				log.Printf("unable to attach token info to context: %s", err.Error())
+				return
+			}
+			r = r.WithContext(ctx)
+			return
+		}
+
 		// this is what Express sessions names cookies by default
 		cookie, err := r.Cookie("connect.sid")
 		if err == http.ErrNoCookie {
@@ -142,6 +225,16 @@ func authenticate(next http.Handler) http.Handler {
 			log.Printf("unable to attach session info to context: %s", err.Error())
 			return
 		}
+
+		// admins can view the site as a candidate or assistant by naming them in a header
+		if target := r.Header.Get("X-Impersonate"); target != "" {
+			if !adminFromContext(ctx) {
+				log.Printf("%s tried to impersonate %s without being an admin", casUserFromContext(ctx), target)
+			} else {
+				ctx = impersonate(ctx, target)
+				log.Printf("impersonation: %s acting as %s: %s %s", realUserFromContext(ctx), casUserFromContext(ctx), r.Method, r.URL.Path)
+			}
+		}
 		r = r.WithContext(ctx)
 	})
 }
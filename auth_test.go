@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestVerifyCookie(t *testing.T) {
+	defer os.Setenv("SESSION_SECRET", os.Getenv("SESSION_SECRET"))
+
+	signed := func(sessionID string, secret string) *http.Cookie {
+		mac := url.QueryEscape(cookieMAC(sessionID, secret))
+		return &http.Cookie{Name: "connect.sid", Value: signedCookiePrefix + sessionID + "." + mac}
+	}
+
+	type testCase struct {
+		expected bool
+		secrets  string
+		cookie   *http.Cookie
+	}
+	cases := []testCase{
+		testCase{
+			expected: true,
+			secrets:  "current",
+			cookie:   signed("abc123", "current"),
+		},
+		testCase{
+			expected: false,
+			secrets:  "current",
+			cookie:   signed("abc123", "old"),
+		},
+		testCase{
+			expected: true,
+			secrets:  "current,old",
+			cookie:   signed("abc123", "old"),
+		},
+		testCase{
+			expected: false,
+			secrets:  "current,old",
+			cookie:   signed("abc123", "older"),
+		},
+		testCase{
+			expected: false,
+			secrets:  "",
+			cookie:   signed("abc123", ""),
+		},
+	}
+
+	for _, c := range cases {
+		os.Setenv("SESSION_SECRET", c.secrets)
+		actual, err := verifyCookie(c.cookie)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+		if actual != c.expected {
+			t.Errorf("secrets %q: expected %t, got %t", c.secrets, c.expected, actual)
+		}
+	}
+
+	malformed := []*http.Cookie{
+		&http.Cookie{Name: "connect.sid", Value: ""},
+		&http.Cookie{Name: "connect.sid", Value: "s%3Aabc123"},
+		&http.Cookie{Name: "connect.sid", Value: "abc123.mac"},
+	}
+	for _, cookie := range malformed {
+		if _, err := verifyCookie(cookie); err != errMalformedCookie {
+			t.Errorf("%q: expected errMalformedCookie, got %v", cookie.Value, err)
+		}
+	}
+}
+
+func TestSignCookie(t *testing.T) {
+	defer os.Setenv("SESSION_SECRET", os.Getenv("SESSION_SECRET"))
+
+	os.Setenv("SESSION_SECRET", "")
+	if _, err := signCookie("abc123"); err == nil {
+		t.Errorf("expected error signing without a secret")
+	}
+
+	os.Setenv("SESSION_SECRET", "current,old")
+	value, err := signCookie("abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	cookie := &http.Cookie{Name: "connect.sid", Value: value}
+	valid, err := verifyCookie(cookie)
+	if err != nil || !valid {
+		t.Errorf("expected signed cookie %q to verify, got %t, %v", value, valid, err)
+	}
+
+	// cookies must be signed with the current secret, not an old one
+	os.Setenv("SESSION_SECRET", "current")
+	valid, _ = verifyCookie(cookie)
+	if !valid {
+		t.Errorf("expected cookie %q to be signed with the first secret", value)
+	}
+}
+
+func TestImpersonate(t *testing.T) {
+	ctx := context.WithValue(context.Background(), casUserKey, "admin1")
+	ctx = context.WithValue(ctx, adminKey, true)
+
+	if impersonatingFromContext(ctx) || realUserFromContext(ctx) != "admin1" {
+		t.Errorf("expected admin1 not to be impersonating anyone")
+	}
+
+	ctx = impersonate(ctx, "LyonJ4")
+	if !impersonatingFromContext(ctx) {
+		t.Errorf("expected to be impersonating")
+	}
+	if casUserFromContext(ctx) != "lyonj4" {
+		t.Errorf("expected effective user lyonj4, got %s", casUserFromContext(ctx))
+	}
+	if realUserFromContext(ctx) != "admin1" {
+		t.Errorf("expected real user admin1, got %s", realUserFromContext(ctx))
+	}
+	if adminFromContext(ctx) {
+		t.Errorf("expected impersonated user not to be an admin")
+	}
+}
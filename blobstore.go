@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var errBlobNotFound = errors.New("blob not found")
+var errInvalidBlobKey = errors.New("invalid blob key")
+
+// blobStore stores uploaded files, such as scanned nomination pages, by key.
+type blobStore interface {
+	Put(key string, r io.Reader) (int64, error)
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// getBlobStore returns the blob store configured by ATTACHMENT_STORE. The only backend so far is
+// "local" (the default), which keeps files in the directory named by ATTACHMENT_DIR.
+func getBlobStore() (blobStore, error) {
+	switch backend := os.Getenv("ATTACHMENT_STORE"); backend {
+	case "", "local":
+		dir := os.Getenv("ATTACHMENT_DIR")
+		if dir == "" {
+			dir = "attachments"
+		}
+		return newLocalBlobStore(dir)
+	default:
+		return nil, fmt.Errorf("unknown attachment store %q", backend)
+	}
+}
+
+// newBlobKey returns a random key for a new blob.
+func newBlobKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// blobKeyPattern matches keys made by newBlobKey, so keys can never escape the store's directory.
+var blobKeyPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// localBlobStore keeps blobs as files in a directory on the local filesystem.
+type localBlobStore struct {
+	dir string
+}
+
+func newLocalBlobStore(dir string) (*localBlobStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &localBlobStore{dir: dir}, nil
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	if !blobKeyPattern.MatchString(key) {
+		return "", errInvalidBlobKey
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes a blob, returning its size. The file is written under a temporary name and renamed
+// into place, so a failed upload never leaves a partial blob.
+func (s *localBlobStore) Put(key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, os.Rename(tmp, path)
+}
+
+func (s *localBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errBlobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
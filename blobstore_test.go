@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elecnoms-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := newBlobKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("%PDF-1.4 scanned page")
+	size, err := store.Put(key, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("expected size %d, got %d", len(content), size)
+	}
+
+	blob, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("expected %q, got %q", content, actual)
+	}
+
+	err = store.Delete(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(key)
+	if err != errBlobNotFound {
+		t.Errorf("expected %v after delete, got %v", errBlobNotFound, err)
+	}
+}
+
+func TestLocalBlobStoreInvalidKeys(t *testing.T) {
+	store := &localBlobStore{dir: os.TempDir()}
+	for _, key := range []string{"", "../../etc/passwd", "abc", "0123456789ABCDEF0123456789ABCDEF"} {
+		if _, err := store.Get(key); err != errInvalidBlobKey {
+			t.Errorf("expected %v for key %q, got %v", errInvalidBlobKey, key, err)
+		}
+		if _, err := store.Put(key, bytes.NewReader(nil)); err != errInvalidBlobKey {
+			t.Errorf("expected %v for key %q, got %v", errInvalidBlobKey, key, err)
+		}
+	}
+}
+
+func TestAttachmentType(t *testing.T) {
+	type testCase struct {
+		expected string
+		allowed  bool
+		head     []byte
+	}
+	cases := []testCase{
+		testCase{expected: "application/pdf", allowed: true, head: []byte("%PDF-1.4\n")},
+		testCase{expected: "image/png", allowed: true, head: []byte("\x89PNG\x0D\x0A\x1A\x0A")},
+		testCase{expected: "image/jpeg", allowed: true, head: []byte("\xFF\xD8\xFF\xE0")},
+		testCase{expected: "text/html; charset=utf-8", allowed: false, head: []byte("<html><body>")},
+	}
+
+	for _, c := range cases {
+		actual, allowed := attachmentType(c.head)
+		if actual != c.expected || allowed != c.allowed {
+			t.Errorf("expected %q (%t), got %q (%t)", c.expected, c.allowed, actual, allowed)
+		}
+	}
+}
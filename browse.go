@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default and maximum number of pages returned by browseNominations at once
+const (
+	defaultBrowseLimit = 50
+	maxBrowseLimit     = 200
+)
+
+// browseSorts maps each sort key to the page columns it orders by. The later columns break ties,
+// so every page has a distinct position for cursors to pick up from.
+var browseSorts = map[string][]string{
+	"submitted": {"submitted", "rcs_id", "office_id", "page"},
+	"candidate": {"rcs_id", "office_id", "page"},
+	"office":    {"office_id", "rcs_id", "page"},
+}
+
+// nominationFilter selects nominations for browseNominations. Empty fields don't filter.
+type nominationFilter struct {
+	Office    string
+	Validity  string
+	Nominator string
+	From      time.Time
+	To        time.Time
+}
+
+// parseNominationFilter reads a filter from the request. If a parameter is invalid, it returns its
+// name along with the error.
+func parseNominationFilter(r *http.Request) (nominationFilter, string, error) {
+	filter := nominationFilter{
+		Office:    r.FormValue("office"),
+		Validity:  r.FormValue("validity"),
+		Nominator: strings.ToLower(r.FormValue("nominator")),
+	}
+	if filter.Office != "" {
+		if _, err := strconv.Atoi(filter.Office); err != nil {
+			return filter, "office", errors.New("office must be a number")
+		}
+	}
+	switch filter.Validity {
+	case "", "valid", "invalid", "pending":
+	default:
+		return filter, "validity", errors.New("validity must be valid, invalid or pending")
+	}
+
+	// dates are whole days, and both ends of the range are included
+	var err error
+	if from := r.FormValue("from"); from != "" {
+		filter.From, err = time.Parse("2006-01-02", from)
+		if err != nil {
+			return filter, "from", errors.New("from must be a date like 2018-03-01")
+		}
+	}
+	if to := r.FormValue("to"); to != "" {
+		filter.To, err = time.Parse("2006-01-02", to)
+		if err != nil {
+			return filter, "to", errors.New("to must be a date like 2018-03-01")
+		}
+	}
+	return filter, "", nil
+}
+
+// where returns the SQL conditions for the filter, for nominations in the active election.
+func (f nominationFilter) where() (string, []interface{}) {
+	conditions := []string{"election_id = " + activeElectionQuery}
+	args := []interface{}{}
+	if f.Office != "" {
+		conditions = append(conditions, "office_id = ?")
+		args = append(args, f.Office)
+	}
+	switch f.Validity {
+	case "valid":
+		conditions = append(conditions, "valid = true")
+	case "invalid":
+		conditions = append(conditions, "valid = false")
+	case "pending":
+		conditions = append(conditions, "valid IS NULL")
+	}
+	if f.Nominator != "" {
+		conditions = append(conditions, "nomination_rcs_id = ?")
+		args = append(args, f.Nominator)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, f.From.Format("2006-01-02"))
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "date < ?")
+		args = append(args, f.To.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// browseCursor is the position of the last page returned, so the next request can continue after it.
+type browseCursor struct {
+	Sort         string    `json:"sort"`
+	Submitted    time.Time `json:"submitted"`
+	CandidateRCS string    `json:"candidate_rcs"`
+	OfficeID     int       `json:"office_id"`
+	Page         int       `json:"page"`
+}
+
+func (c browseCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeBrowseCursor(s string) (browseCursor, error) {
+	cursor := browseCursor{}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// values returns the cursor's position in the columns of its sort.
+func (c browseCursor) values() []interface{} {
+	values := []interface{}{}
+	for _, column := range browseSorts[strings.TrimPrefix(c.Sort, "-")] {
+		switch column {
+		case "submitted":
+			values = append(values, c.Submitted.Format("2006-01-02 15:04:05"))
+		case "rcs_id":
+			values = append(values, c.CandidateRCS)
+		case "office_id":
+			values = append(values, c.OfficeID)
+		case "page":
+			values = append(values, c.Page)
+		}
+	}
+	return values
+}
+
+// browseResult is a batch of pages from browseNominations. NextCursor is nil on the last batch.
+type browseResult struct {
+	Pages      []NominationPage `json:"pages"`
+	NextCursor *string          `json:"next_cursor"`
+}
+
+// browseNominations lists nomination pages across all candidates in the active election.
+// Nominations can be filtered by office, validity (valid, invalid or pending), nominator RCS ID and
+// submission date (from and to, inclusive); pages only include the nominations that match.
+// Pages are sorted by submitted (the default), candidate or office, descending if the key starts
+// with "-". Up to limit pages are returned, along with a cursor to pass back to get the next batch.
+// Requires authorization, and only admins can use it.
+func browseNominations(w http.ResponseWriter, r *http.Request) {
+	// check if this user has permission to do this
+	admin := adminFromContext(r.Context())
+	if !admin {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	filter, field, err := parseNominationFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, field, err.Error())
+		return
+	}
+
+	sortKey := r.FormValue("sort")
+	if sortKey == "" {
+		sortKey = "submitted"
+	}
+	direction, comparison := "ASC", ">"
+	if strings.HasPrefix(sortKey, "-") {
+		direction, comparison = "DESC", "<"
+	}
+	columns, ok := browseSorts[strings.TrimPrefix(sortKey, "-")]
+	if !ok {
+		writeError(w, r, http.StatusUnprocessableEntity, "sort", "sort must be submitted, candidate or office")
+		return
+	}
+
+	limit := defaultBrowseLimit
+	if l := r.FormValue("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxBrowseLimit {
+			writeError(w, r, http.StatusUnprocessableEntity, "limit", "limit must be between 1 and "+strconv.Itoa(maxBrowseLimit))
+			return
+		}
+	}
+
+	// find the pages in this batch
+	where, args := filter.where()
+	query := "SELECT rcs_id, office_id, page, submitted FROM (SELECT rcs_id, office_id, page, MAX(date) AS submitted FROM nominations WHERE " + where + " GROUP BY rcs_id, office_id, page) p"
+	if c := r.FormValue("cursor"); c != "" {
+		cursor, err := decodeBrowseCursor(c)
+		if err != nil || cursor.Sort != sortKey {
+			writeError(w, r, http.StatusUnprocessableEntity, "cursor", "invalid cursor")
+			return
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+		query += " WHERE (" + strings.Join(columns, ", ") + ") " + comparison + " (" + placeholders + ")"
+		args = append(args, cursor.values()...)
+	}
+	query += " ORDER BY " + strings.Join(columns, " "+direction+", ") + " " + direction + " LIMIT ?"
+	args = append(args, limit+1)
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	cursors := []browseCursor{}
+	for rows.Next() {
+		cursor := browseCursor{Sort: sortKey}
+		err = rows.Scan(&cursor.CandidateRCS, &cursor.OfficeID, &cursor.Page, &cursor.Submitted)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		cursors = append(cursors, cursor)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	result := browseResult{Pages: []NominationPage{}}
+	if len(cursors) > limit {
+		cursors = cursors[:limit]
+		next := cursors[limit-1].encode()
+		result.NextCursor = &next
+	}
+	if len(cursors) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.Encode(result)
+		return
+	}
+
+	// get the matching nominations on those pages
+	where, args = filter.where()
+	pageConditions := []string{}
+	for _, cursor := range cursors {
+		pageConditions = append(pageConditions, "(rcs_id = ? AND office_id = ? AND page = ?)")
+		args = append(args, cursor.CandidateRCS, cursor.OfficeID, cursor.Page)
+	}
+	rows, err = db.Query("SELECT nomination_id, nomination_partial_rin, nomination_rcs_id, valid, page, number, rcs_id, office_id, date FROM nominations WHERE "+where+" AND ("+strings.Join(pageConditions, " OR ")+") ORDER BY number", args...)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	nominations := []pageNomination{}
+	for rows.Next() {
+		nomination := pageNomination{}
+		err = rows.Scan(&nomination.ID, &nomination.RIN, &nomination.RcsID, &nomination.Valid, &nomination.Page, &nomination.Number, &nomination.CandidateRCS, &nomination.OfficeID, &nomination.Submitted)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		nominations = append(nominations, nomination)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// put the pages back in the order of the batch
+	pages := groupPages(nominations)
+	order := map[string]int{}
+	for i, cursor := range cursors {
+		order[cursor.CandidateRCS+"\x00"+strconv.Itoa(cursor.OfficeID)+"\x00"+strconv.Itoa(cursor.Page)] = i
+	}
+	ordered := make([]NominationPage, len(cursors))
+	for _, page := range pages {
+		i := order[page.CandidateRCS+"\x00"+strconv.Itoa(page.OfficeID)+"\x00"+strconv.Itoa(page.Number)]
+		page.Submitted = cursors[i].Submitted
+		ordered[i] = page
+	}
+	// a page can disappear if its nominations change between the queries
+	for _, page := range ordered {
+		if page.Nominations != nil {
+			result.Pages = append(result.Pages, page)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(result)
+}
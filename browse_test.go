@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroupPages(t *testing.T) {
+	submitted := time.Date(2018, time.March, 1, 12, 30, 0, 0, time.UTC)
+	nominations := []pageNomination{
+		pageNomination{Nomination: Nomination{ID: 1, Page: 1, Number: 1}, CandidateRCS: "lyonj4", OfficeID: 3, Submitted: submitted},
+		pageNomination{Nomination: Nomination{ID: 2, Page: 1, Number: 1}, CandidateRCS: "kochms", OfficeID: 3, Submitted: submitted},
+		pageNomination{Nomination: Nomination{ID: 3, Page: 1, Number: 2}, CandidateRCS: "lyonj4", OfficeID: 3, Submitted: submitted.Add(time.Minute)},
+		pageNomination{Nomination: Nomination{ID: 4, Page: 1, Number: 1}, CandidateRCS: "lyonj4", OfficeID: 4, Submitted: submitted},
+		// a page is dated by its latest nomination, not its last line
+		pageNomination{Nomination: Nomination{ID: 5, Page: 1, Number: 3}, CandidateRCS: "lyonj4", OfficeID: 3, Submitted: submitted},
+	}
+	expected := []NominationPage{
+		NominationPage{Number: 1, CandidateRCS: "lyonj4", OfficeID: 3, Submitted: submitted.Add(time.Minute), Nominations: []Nomination{
+			Nomination{ID: 1, Page: 1, Number: 1},
+			Nomination{ID: 3, Page: 1, Number: 2},
+			Nomination{ID: 5, Page: 1, Number: 3},
+		}},
+		NominationPage{Number: 1, CandidateRCS: "kochms", OfficeID: 3, Submitted: submitted, Nominations: []Nomination{
+			Nomination{ID: 2, Page: 1, Number: 1},
+		}},
+		NominationPage{Number: 1, CandidateRCS: "lyonj4", OfficeID: 4, Submitted: submitted, Nominations: []Nomination{
+			Nomination{ID: 4, Page: 1, Number: 1},
+		}},
+	}
+
+	actual := groupPages(nominations)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestNominationFilterWhere(t *testing.T) {
+	type testCase struct {
+		expected     string
+		expectedArgs []interface{}
+		filter       nominationFilter
+	}
+	cases := []testCase{
+		testCase{
+			expected:     "election_id = " + activeElectionQuery,
+			expectedArgs: []interface{}{},
+			filter:       nominationFilter{},
+		},
+		testCase{
+			expected:     "election_id = " + activeElectionQuery + " AND office_id = ? AND valid IS NULL AND nomination_rcs_id = ?",
+			expectedArgs: []interface{}{"3", "kochms"},
+			filter:       nominationFilter{Office: "3", Validity: "pending", Nominator: "kochms"},
+		},
+		testCase{
+			expected:     "election_id = " + activeElectionQuery + " AND valid = false AND date >= ? AND date < ?",
+			expectedArgs: []interface{}{"2018-03-01", "2018-03-08"},
+			filter:       nominationFilter{Validity: "invalid", From: time.Date(2018, time.March, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2018, time.March, 7, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, c := range cases {
+		actual, args := c.filter.where()
+		if actual != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, actual)
+		}
+		if !reflect.DeepEqual(args, c.expectedArgs) {
+			t.Errorf("expected args %v, got %v", c.expectedArgs, args)
+		}
+	}
+}
+
+func TestBrowseCursor(t *testing.T) {
+	cursor := browseCursor{Sort: "-submitted", Submitted: time.Date(2018, time.March, 1, 12, 30, 0, 0, time.UTC), CandidateRCS: "lyonj4", OfficeID: 3, Page: 2}
+	decoded, err := decodeBrowseCursor(cursor.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{"2018-03-01 12:30:00", "lyonj4", 3, 2}
+	if actual := decoded.values(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if _, err = decodeBrowseCursor("not a cursor"); err == nil {
+		t.Error("expected error decoding invalid cursor")
+	}
+}
+
+func TestBrowseNominationsFilterErrors(t *testing.T) {
+	type testCase struct {
+		target string
+		field  string
+	}
+	cases := []testCase{
+		testCase{target: "/nominations?office=president", field: "office"},
+		testCase{target: "/nominations?validity=maybe", field: "validity"},
+		testCase{target: "/nominations?from=yesterday", field: "from"},
+		testCase{target: "/nominations?to=tomorrow", field: "to"},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		browseNominations(w, httptest.NewRequest(http.MethodGet, c.target, nil).WithContext(userContext("admin1", true)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status 422, got %d", c.target, w.Code)
+			continue
+		}
+		resp := struct {
+			Error apiError `json:"error"`
+		}{}
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error.Field != c.field {
+			t.Errorf("%s: expected field %q, got %+v", c.target, c.field, resp.Error)
+		}
+	}
+}
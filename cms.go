@@ -80,13 +80,22 @@ func (c *CMSInfo) graduate() bool {
 }
 
 func (c *CMSInfo) creditCohort() string {
-	year := time.Now().Year()
+	year := clock().Year()
 	cohortYear := year + cohortOffsets[strings.ToLower(c.ClassByCredit)]
 
 	return strconv.Itoa(cohortYear)
 }
 
+// entryCohort returns the class year the student entered with, which is their graduation year.
+// Not everyone has a graduation date in CMS, so it falls back to four years after their entry date,
+// and it is empty if neither is known.
 func (c *CMSInfo) entryCohort() string {
+	if c.GraduationDate.IsZero() {
+		if c.EntryDate.IsZero() {
+			return ""
+		}
+		return strconv.Itoa(c.EntryDate.Year() + 4)
+	}
 	cohortYear := c.GraduationDate.Format("2006")
 	return cohortYear
 }
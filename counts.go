@@ -17,7 +17,7 @@ func nominationCounts(w http.ResponseWriter, r *http.Request) {
 	db, err := getDB()
 	if err != nil {
 		log.Printf("unable to get database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 	defer db.Close()
@@ -34,7 +34,7 @@ func nominationCounts(w http.ResponseWriter, r *http.Request) {
 	}
 	if err != nil {
 		log.Printf("unable to query database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 
@@ -44,7 +44,7 @@ func nominationCounts(w http.ResponseWriter, r *http.Request) {
 		err := rows.Scan(&nomCount.RCSID, &nomCount.OfficeID, &nomCount.Nominations)
 		if err != nil {
 			log.Printf("unable to scan: %s", err.Error())
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeStatus(w, r, http.StatusInternalServerError)
 			return
 		}
 		nominations = append(nominations, nomCount)
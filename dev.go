@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// devSessionLength is how long sessions created by devLogin last.
+const devSessionLength = 24 * time.Hour
+
+// devMode returns whether development-only endpoints are enabled. It must never be set in production.
+func devMode() bool {
+	return os.Getenv("DEV_MODE") == "true"
+}
+
+// devLogin creates a session for any RCS ID and sets the session cookie, so elecnoms can be used
+// locally without the elections app or CAS. Pass admin=true to act as an R&E member.
+// To act as an assistant, log in as someone in the assistants table.
+// It is a GET so that it can be visited from a browser, and it is only registered in dev mode.
+func devLogin(w http.ResponseWriter, r *http.Request) {
+	rcs := strings.ToLower(r.FormValue("rcs"))
+	if rcs == "" {
+		writeMissing(w, r, "rcs", "missing rcs")
+		return
+	}
+	admin := r.FormValue("admin") == "true"
+
+	// generate session ID like uid-safe, which express-session uses
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Printf("unable to generate session ID: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	sessionID := base64.RawURLEncoding.EncodeToString(b)
+
+	value, err := signCookie(sessionID)
+	if err != nil {
+		log.Printf("unable to sign cookie: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	sd := sessionData{
+		CASUser:       rcs,
+		Authenticated: true,
+		ECMember:      admin,
+	}
+	data, err := json.Marshal(sd)
+	if err != nil {
+		log.Printf("unable to encode JSON: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	expires := time.Now().Add(devSessionLength)
+	_, err = db.Exec("INSERT INTO sessions (session_id, expires, data) VALUES (?, ?, ?)", sessionID, expires.Unix(), data)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("dev mode: logged in as %s (admin: %t)", rcs, admin)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "connect.sid",
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(sd)
+}
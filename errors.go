@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// errorCodes are the codes of error responses, by status. Other statuses use their status text.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "invalid_parameter",
+	http.StatusInternalServerError:   "internal_error",
+}
+
+// apiError describes what went wrong with a request. Field names the request parameter or body field
+// at fault, if there is one, and RequestID matches the server's logs. Lines are only included when a
+// nomination page is rejected, and Rows when an import is.
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Field     string      `json:"field,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Lines     []lineError `json:"lines,omitempty"`
+	Rows      []rowError  `json:"rows,omitempty"`
+}
+
+// errorCode returns the code for an error response with a status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return strings.ToLower(strings.Replace(http.StatusText(status), " ", "_", -1))
+}
+
+// writeError responds with an error. field may be empty.
+func writeError(w http.ResponseWriter, r *http.Request, status int, field string, message string) {
+	sendError(w, r, status, apiError{Message: message, Field: field})
+}
+
+// writeMissing responds that a required parameter or body field wasn't given, which has its own code
+// so clients can tell it apart from one with an invalid value.
+func writeMissing(w http.ResponseWriter, r *http.Request, field string, message string) {
+	sendError(w, r, http.StatusUnprocessableEntity, apiError{Code: "missing_parameter", Message: message, Field: field})
+}
+
+// sendError responds with an error, as a JSON object with the apiError under "error". The code and
+// request ID are filled in if they're empty.
+func sendError(w http.ResponseWriter, r *http.Request, status int, e apiError) {
+	if e.Code == "" {
+		e.Code = errorCode(status)
+	}
+	if e.RequestID == "" {
+		e.RequestID = middleware.GetReqID(r.Context())
+	}
+	resp := struct {
+		Error apiError `json:"error"`
+	}{e}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.Encode(resp)
+}
+
+// writeStatus responds with an error that needs no more explanation than its status.
+func writeStatus(w http.ResponseWriter, r *http.Request, status int) {
+	writeError(w, r, status, "", http.StatusText(status))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+func TestErrorCode(t *testing.T) {
+	type testCase struct {
+		expected string
+		status   int
+	}
+	cases := []testCase{
+		testCase{expected: "invalid_parameter", status: http.StatusUnprocessableEntity},
+		testCase{expected: "not_found", status: http.StatusNotFound},
+		testCase{expected: "internal_error", status: http.StatusInternalServerError},
+		testCase{expected: "method_not_allowed", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, c := range cases {
+		actual := errorCode(c.status)
+		if actual != c.expected {
+			t.Errorf("expected %q for %d, got %q", c.expected, c.status, actual)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	var requestID string
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = middleware.GetReqID(r.Context())
+		writeMissing(w, r, "rcs", "missing rcs")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected application/json, got %q", ct)
+	}
+	resp := struct {
+		Error apiError `json:"error"`
+	}{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := apiError{Code: "missing_parameter", Message: "missing rcs", Field: "rcs", RequestID: requestID}
+	if requestID == "" || !reflect.DeepEqual(resp.Error, expected) {
+		t.Errorf("expected %+v, got %+v", expected, resp.Error)
+	}
+}
+
+func TestWriteSubmissionError(t *testing.T) {
+	w := httptest.NewRecorder()
+	lines := []lineError{lineError{Index: 1, Field: "rin", Message: "Partial RIN must be exactly three digits."}}
+	writeSubmissionError(w, httptest.NewRequest(http.MethodPost, "/", nil), "", "invalid nominations", lines)
+
+	resp := struct {
+		Error apiError `json:"error"`
+	}{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := apiError{Code: "invalid_nominations", Message: "invalid nominations", Lines: lines}
+	if w.Code != http.StatusUnprocessableEntity || !reflect.DeepEqual(resp.Error, expected) {
+		t.Errorf("expected %d %+v, got %d %+v", http.StatusUnprocessableEntity, expected, w.Code, resp.Error)
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	type testCase struct {
+		method string
+		target string
+		status int
+		code   string
+	}
+	cases := []testCase{
+		testCase{method: http.MethodGet, target: "/no/such/route", status: http.StatusNotFound, code: "not_found"},
+		testCase{method: http.MethodDelete, target: "/counts", status: http.StatusMethodNotAllowed, code: "method_not_allowed"},
+	}
+
+	router := newRouter()
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(c.method, c.target, nil))
+
+		resp := struct {
+			Error apiError `json:"error"`
+		}{}
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		if err != nil {
+			t.Errorf("%s %s: unable to parse body: %s", c.method, c.target, err.Error())
+			continue
+		}
+		if w.Code != c.status || resp.Error.Code != c.code || resp.Error.RequestID == "" {
+			t.Errorf("%s %s: expected %d %s, got %d %+v", c.method, c.target, c.status, c.code, w.Code, resp.Error)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event types
+const (
+	eventPageSubmitted      = "page.submitted"
+	eventNominationModified = "nomination.modified"
+	eventNominationChecked  = "nomination.checked"
+)
+
+// eventBuffer is how many events a subscriber can fall behind by before events are dropped for it.
+const eventBuffer = 32
+
+// sseKeepalive is how often a comment is sent on an idle event stream, so proxies don't close it.
+var sseKeepalive = 30 * time.Second
+
+// event is a change to a candidate's nominations. Valid is the nomination's validity (nil while
+// pending, or for page events), ValidChanged is whether a modification changed it, and ValidCount
+// is the candidate's number of valid nominations for the office after the change.
+type event struct {
+	Type         string   `json:"type"`
+	CandidateRCS string   `json:"candidate_rcs"`
+	OfficeID     int      `json:"office_id"`
+	Page         int      `json:"page,omitempty"`
+	NominationID int      `json:"nomination_id,omitempty"`
+	Valid        *bool    `json:"valid"`
+	ValidChanged bool     `json:"valid_changed"`
+	Problems     Problems `json:"problems,omitempty"`
+	ValidCount   int      `json:"valid_count"`
+}
+
+// eventHub passes events from the handlers that change nominations to everyone subscribed to them.
+// Publishing never blocks: a subscriber that isn't keeping up misses events.
+type eventHub struct {
+	mutex       sync.Mutex
+	subscribers map[chan event]string
+}
+
+var hub = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: map[chan event]string{}}
+}
+
+// subscribe returns a channel of events for a candidate, or for every candidate if rcs is empty.
+func (h *eventHub) subscribe(rcs string) chan event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ch := make(chan event, eventBuffer)
+	h.subscribers[ch] = rcs
+	return ch
+}
+
+// unsubscribe stops sending events to a channel returned by subscribe.
+func (h *eventHub) unsubscribe(ch chan event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.subscribers, ch)
+}
+
+func (h *eventHub) publish(e event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for ch, rcs := range h.subscribers {
+		if rcs != "" && rcs != e.CandidateRCS {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// validCount returns how many valid nominations a candidate has for an office in the active election.
+func validCount(q rowQueryer, rcs string, office int) (int, error) {
+	var count int
+	row := q.QueryRow("SELECT COUNT(*) FROM nominations WHERE rcs_id = ? AND office_id = ? AND valid = true AND election_id = "+activeElectionQuery, rcs, office)
+	err := row.Scan(&count)
+	return count, err
+}
+
+// lockValidCount returns how many valid nominations the candidate of a nomination has for its
+// office in the active election. It locks all of the candidate's nominations for the office, so
+// changes to them are counted one transaction at a time, and it sees the transaction's own changes.
+func lockValidCount(q rowQueryer, nominationID int) (int, error) {
+	var count int
+	row := q.QueryRow("SELECT COALESCE(SUM(n.valid = true), 0) FROM nominations m JOIN nominations n ON n.rcs_id = m.rcs_id AND n.office_id = m.office_id AND n.election_id = "+activeElectionQuery+" WHERE m.nomination_id = ? FOR UPDATE", nominationID)
+	err := row.Scan(&count)
+	return count, err
+}
+
+// pageEvent describes a newly submitted page.
+func pageEvent(q rowQueryer, rcs string, office string, page int) (event, error) {
+	officeID, err := strconv.Atoi(office)
+	if err != nil {
+		return event{}, err
+	}
+	e := event{Type: eventPageSubmitted, CandidateRCS: rcs, OfficeID: officeID, Page: page}
+	e.ValidCount, err = validCount(q, rcs, officeID)
+	return e, err
+}
+
+// nominationEvent describes a change to a nomination, as it is now stored.
+func nominationEvent(q rowQueryer, eventType string, nominationID int, validChanged bool) (event, error) {
+	e := event{Type: eventType, NominationID: nominationID, ValidChanged: validChanged}
+	row := q.QueryRow("SELECT rcs_id, office_id, page, valid FROM nominations WHERE nomination_id = ?", nominationID)
+	err := row.Scan(&e.CandidateRCS, &e.OfficeID, &e.Page, &e.Valid)
+	if err != nil {
+		return e, err
+	}
+	e.CandidateRCS = strings.ToLower(e.CandidateRCS)
+	e.ValidCount, err = validCount(q, e.CandidateRCS, e.OfficeID)
+	return e, err
+}
+
+// publishPage announces a newly submitted page. Errors are only logged, since the page has already
+// been stored.
+func publishPage(q rowQueryer, rcs string, office string, page int) {
+	e, err := pageEvent(q, rcs, office, page)
+	if err != nil {
+		log.Printf("unable to publish event: %s", err.Error())
+		return
+	}
+	hub.publish(e)
+}
+
+// publishNomination announces a change to a nomination, as it is now stored. Errors are only
+// logged, since the change has already been made.
+func publishNomination(q rowQueryer, eventType string, nominationID int, validChanged bool) {
+	e, err := nominationEvent(q, eventType, nominationID, validChanged)
+	if err != nil {
+		log.Printf("unable to publish event: %s", err.Error())
+		return
+	}
+	hub.publish(e)
+}
+
+// publishValidation announces the result of validating a nomination. Errors are only logged, since
+// the result has already been saved.
+func publishValidation(q rowQueryer, rcs string, office int, nominationID int, vn ValidNomination) {
+	rcs = strings.ToLower(rcs)
+	valid := vn.Valid
+	e := event{Type: eventNominationChecked, CandidateRCS: rcs, OfficeID: office, NominationID: nominationID, Valid: &valid, Problems: vn.Problems}
+	var err error
+	e.ValidCount, err = validCount(q, rcs, office)
+	if err != nil {
+		log.Printf("unable to publish event: %s", err.Error())
+		return
+	}
+	hub.publish(e)
+}
+
+// writeEvent writes an event in the Server-Sent Events format, named by its type.
+func writeEvent(w io.Writer, e event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
+
+// streamEvents sends changes to a candidate's nominations as Server-Sent Events as they happen:
+// pages being submitted, nominations being marked valid or invalid, and validation results. Each
+// event includes the candidate's updated count of valid nominations for the office. Admins can leave
+// out the RCS ID to get events for every candidate.
+// Events come from the given hub.
+// Authorization is required, and people with permission are admins, the candidate with the specified RCS ID, and her assistants.
+func streamEvents(h *eventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamHubEvents(h, w, r)
+	}
+}
+
+func streamHubEvents(h *eventHub, w http.ResponseWriter, r *http.Request) {
+	rcs := strings.ToLower(r.FormValue("rcs"))
+
+	// check if this user has permission to do this
+	allowed := adminFromContext(r.Context())
+	if !allowed && rcs != "" {
+		var err error
+		allowed, err = canActForCandidate(r.Context(), rcs)
+		if err != nil {
+			log.Printf("unable to get candidate assistants: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	if !allowed {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Print("unable to stream events: response can't be flushed")
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	ch := h.subscribe(rcs)
+	defer h.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			err = writeEvent(w, e)
+		case <-keepalive.C:
+			_, err = io.WriteString(w, ": keepalive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventHub(t *testing.T) {
+	h := newEventHub()
+	all := h.subscribe("")
+	candidate := h.subscribe("lyonj4")
+	other := h.subscribe("kochms")
+
+	h.publish(event{Type: eventPageSubmitted, CandidateRCS: "lyonj4", OfficeID: 3, Page: 1})
+
+	for name, ch := range map[string]chan event{"all": all, "candidate": candidate} {
+		select {
+		case e := <-ch:
+			if e.CandidateRCS != "lyonj4" {
+				t.Errorf("%s: expected event for lyonj4, got %+v", name, e)
+			}
+		default:
+			t.Errorf("%s: expected an event", name)
+		}
+	}
+	select {
+	case e := <-other:
+		t.Errorf("expected no event for another candidate, got %+v", e)
+	default:
+	}
+
+	// a subscriber that falls behind doesn't hold up publishing
+	for i := 0; i < eventBuffer*2; i++ {
+		h.publish(event{Type: eventPageSubmitted, CandidateRCS: "lyonj4"})
+	}
+	if len(candidate) != eventBuffer {
+		t.Errorf("expected %d buffered events, got %d", eventBuffer, len(candidate))
+	}
+
+	h.unsubscribe(all)
+	if _, ok := h.subscribers[all]; ok {
+		t.Error("expected channel to be unsubscribed")
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	valid := false
+	var buf bytes.Buffer
+	err := writeEvent(&buf, event{Type: eventNominationModified, CandidateRCS: "lyonj4", OfficeID: 3, Page: 1, NominationID: 7, Valid: &valid, ValidChanged: true, ValidCount: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "event: nomination.modified\ndata: {\"type\":\"nomination.modified\",\"candidate_rcs\":\"lyonj4\",\"office_id\":3,\"page\":1,\"nomination_id\":7,\"valid\":false,\"valid_changed\":true,\"valid_count\":12}\n\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+// flushRecorder records a response and reports each flush, so a test can wait for a stream to
+// send what it has.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan struct{}
+}
+
+func (f flushRecorder) Flush() {
+	f.ResponseRecorder.Flush()
+	f.flushed <- struct{}{}
+}
+
+func TestStreamEvents(t *testing.T) {
+	h := newEventHub()
+	ctx, cancel := context.WithCancel(userContext("admin1", true))
+	w := flushRecorder{httptest.NewRecorder(), make(chan struct{})}
+	r := httptest.NewRequest(http.MethodGet, "/events?rcs=lyonj4", nil).WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		streamEvents(h)(w, r)
+		close(done)
+	}()
+
+	// the headers are flushed once the stream has subscribed
+	<-w.flushed
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+	h.publish(event{Type: eventPageSubmitted, CandidateRCS: "kochms", OfficeID: 3, Page: 1})
+	h.publish(event{Type: eventPageSubmitted, CandidateRCS: "lyonj4", OfficeID: 3, Page: 2})
+	<-w.flushed
+	cancel()
+	<-done
+
+	body := w.Body.String()
+	if strings.Count(body, "event: page.submitted") != 1 || !strings.Contains(body, `"candidate_rcs":"lyonj4"`) {
+		t.Errorf("expected one event for lyonj4, got %q", body)
+	}
+	if len(h.subscribers) != 0 {
+		t.Error("expected the stream to unsubscribe when the request ended")
+	}
+}
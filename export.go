@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushRows is how many CSV rows are written between flushes to the client.
+const exportFlushRows = 100
+
+var exportHeader = []string{"candidate_rcs", "office_id", "page", "number", "partial_rin", "nominator_rcs", "validity", "problems", "submitted"}
+
+// exportRow is one nomination in a CSV export.
+type exportRow struct {
+	CandidateRCS string
+	OfficeID     int
+	Page         int
+	Number       int
+	PartialRIN   string
+	NominatorRCS string
+	Valid        *bool
+	Problems     Problems
+	Submitted    time.Time
+}
+
+// validityLabel describes a nomination's validity for people reading an export.
+func validityLabel(valid *bool) string {
+	if valid == nil {
+		return "pending"
+	}
+	if *valid {
+		return "valid"
+	}
+	return "invalid"
+}
+
+// record returns the row as CSV fields, in the order of exportHeader.
+func (e exportRow) record() []string {
+	problems := []string{}
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Message)
+	}
+	return []string{
+		e.CandidateRCS,
+		strconv.Itoa(e.OfficeID),
+		strconv.Itoa(e.Page),
+		strconv.Itoa(e.Number),
+		e.PartialRIN,
+		e.NominatorRCS,
+		validityLabel(e.Valid),
+		strings.Join(problems, " "),
+		e.Submitted.Format(time.RFC3339),
+	}
+}
+
+// exportNominations writes every nomination in an election as CSV, for building official results sheets.
+// The election defaults to the active one. Rows are streamed as they are read so large elections
+// aren't buffered in memory. Problems come from the most recent validation of each nomination.
+// Requires authorization, and only admins can use it.
+func exportNominations(w http.ResponseWriter, r *http.Request) {
+	// check if this user has permission to do this
+	admin := adminFromContext(r.Context())
+	if !admin {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	electionClause := activeElectionQuery
+	args := []interface{}{}
+	election := r.FormValue("election")
+	if election != "" {
+		if _, err := strconv.Atoi(election); err != nil {
+			writeError(w, r, http.StatusUnprocessableEntity, "election", "election must be a number")
+			return
+		}
+		electionClause = "?"
+		args = append(args, election)
+	} else {
+		election = "active"
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT n.rcs_id, n.office_id, n.page, n.number, n.nomination_partial_rin, n.nomination_rcs_id, n.valid, COALESCE(r.problems, '[]'), n.date FROM nominations n LEFT JOIN nomination_reviews r ON r.nomination_id = n.nomination_id WHERE n.election_id = "+electionClause+" ORDER BY n.rcs_id, n.office_id, n.page, n.number", args...)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"nominations-"+election+".csv\"")
+	flusher, _ := w.(http.Flusher)
+	out := csv.NewWriter(w)
+	out.Write(exportHeader)
+
+	// once rows have been written, errors can only be logged and the export cut short
+	count := 0
+	for rows.Next() {
+		row := exportRow{}
+		var problems string
+		err = rows.Scan(&row.CandidateRCS, &row.OfficeID, &row.Page, &row.Number, &row.PartialRIN, &row.NominatorRCS, &row.Valid, &problems, &row.Submitted)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			return
+		}
+		err = json.Unmarshal([]byte(problems), &row.Problems)
+		if err != nil {
+			log.Printf("unable to decode JSON: %s", err.Error())
+			return
+		}
+		out.Write(row.record())
+
+		count++
+		if count%exportFlushRows == 0 {
+			out.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		return
+	}
+	out.Flush()
+	if err = out.Error(); err != nil {
+		log.Printf("unable to write CSV: %s", err.Error())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportRowRecord(t *testing.T) {
+	valid := true
+	invalid := false
+	submitted := time.Date(2018, time.March, 1, 12, 30, 0, 0, time.UTC)
+
+	type testCase struct {
+		expected []string
+		row      exportRow
+	}
+	cases := []testCase{
+		testCase{
+			expected: []string{"lyonj4", "3", "1", "2", "777", "kochms", "pending", "", "2018-03-01T12:30:00Z"},
+			row:      exportRow{CandidateRCS: "lyonj4", OfficeID: 3, Page: 1, Number: 2, PartialRIN: "777", NominatorRCS: "kochms", Submitted: submitted},
+		},
+		testCase{
+			expected: []string{"lyonj4", "3", "1", "3", "999", "smithj", "valid", "", "2018-03-01T12:30:00Z"},
+			row:      exportRow{CandidateRCS: "lyonj4", OfficeID: 3, Page: 1, Number: 3, PartialRIN: "999", NominatorRCS: "smithj", Valid: &valid, Submitted: submitted},
+		},
+		testCase{
+			expected: []string{"lyonj4", "3", "2", "1", "123", "doej", "invalid", "Not a student. Mismatched RIN digits.", "2018-03-01T12:30:00Z"},
+			row:      exportRow{CandidateRCS: "lyonj4", OfficeID: 3, Page: 2, Number: 1, PartialRIN: "123", NominatorRCS: "doej", Valid: &invalid, Problems: Problems{problem(codeNotStudent, "Not a student."), problem(codeRINMismatch, "Mismatched RIN digits.")}, Submitted: submitted},
+		},
+	}
+
+	for _, c := range cases {
+		actual := c.row.record()
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("expected %q, got %q", c.expected, actual)
+		}
+		if len(actual) != len(exportHeader) {
+			t.Errorf("expected %d fields, got %d", len(exportHeader), len(actual))
+		}
+	}
+}
+
+func TestExportNominations(t *testing.T) {
+	submitted := time.Date(2018, time.March, 1, 12, 30, 0, 0, time.UTC)
+	exportColumns := []string{"rcs_id", "office_id", "page", "number", "nomination_partial_rin", "nomination_rcs_id", "valid", "problems", "date"}
+	// enough rows to be flushed to the client partway through
+	many := [][]driver.Value{}
+	for i := 1; i <= exportFlushRows+1; i++ {
+		many = append(many, []driver.Value{"lyonj4", int64(3), int64(1), int64(i), "777", "kochms", nil, `[]`, submitted})
+	}
+	handler := requireScope(scopeReadNominations)(http.HandlerFunc(exportNominations))
+
+	type testCase struct {
+		expected int
+		ctx      context.Context
+		target   string
+		queries  []fakeQuery
+		// what's expected of a successful export: the query's arguments, the attachment name, the
+		// number of CSV lines and the first row
+		args     []driver.Value
+		filename string
+		lines    int
+		first    []string
+	}
+	cases := []testCase{
+		testCase{expected: http.StatusUnauthorized, ctx: userContext("lyonj4", false), target: "/export"},
+		testCase{expected: http.StatusForbidden, ctx: tokenContext(context.Background(), "results", []scope{scopeReadCounts, scopeAdmin}), target: "/export"},
+		testCase{expected: http.StatusUnauthorized, ctx: tokenContext(context.Background(), "results", []scope{scopeReadNominations}), target: "/export"},
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("admin1", true), target: "/export?election=latest"},
+		testCase{
+			expected: http.StatusOK, ctx: userContext("admin1", true), target: "/export",
+			queries: []fakeQuery{fakeQuery{match: "FROM nominations n LEFT JOIN nomination_reviews r", columns: exportColumns, rows: [][]driver.Value{
+				[]driver.Value{"lyonj4", int64(3), int64(1), int64(1), "777", "kochms", int64(0), `["Not a student."]`, submitted},
+				[]driver.Value{"lyonj4", int64(3), int64(1), int64(2), "999", "smithj", int64(1), `[]`, submitted},
+			}}},
+			args: []driver.Value{}, filename: "nominations-active.csv", lines: 3,
+			first: []string{"lyonj4", "3", "1", "1", "777", "kochms", "invalid", "Not a student.", "2018-03-01T12:30:00Z"},
+		},
+		testCase{
+			expected: http.StatusOK, ctx: tokenContext(context.Background(), "results", []scope{scopeReadNominations, scopeAdmin}), target: "/export?election=4",
+			queries: []fakeQuery{fakeQuery{match: "WHERE n.election_id = ?", columns: exportColumns, rows: many}},
+			args:    []driver.Value{"4"}, filename: "nominations-4.csv", lines: exportFlushRows + 2,
+			first: []string{"lyonj4", "3", "1", "1", "777", "kochms", "pending", "", "2018-03-01T12:30:00Z"},
+		},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.target, nil).WithContext(c.ctx))
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expected, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+			t.Errorf("%s: expected text/csv, got %q", c.target, ct)
+		}
+		if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="`+c.filename+`"` {
+			t.Errorf("%s: unexpected Content-Disposition %q", c.target, disposition)
+		}
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != c.lines || !reflect.DeepEqual(records[0], exportHeader) || !reflect.DeepEqual(records[1], c.first) {
+			t.Errorf("%s: expected a header and %d rows starting with %q, got %q", c.target, c.lines-1, c.first, records)
+		}
+		if c.lines > exportFlushRows && !w.Flushed {
+			t.Errorf("%s: expected the rows to be flushed as they were written", c.target)
+		}
+		if calls := db.called("FROM nominations n"); len(calls) != 1 || !reflect.DeepEqual(calls[0].args, c.args) {
+			t.Errorf("%s: expected a query with %v, got %+v", c.target, c.args, calls)
+		}
+	}
+}
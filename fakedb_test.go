@@ -0,0 +1,166 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeQuery is a statement the fake database expects. It matches any statement containing match,
+// and is used up by the first one.
+type fakeQuery struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+	// affected is the number of rows an exec changes
+	affected int64
+	// insertID is the ID an exec inserts
+	insertID int64
+	err      error
+	// rowsErr ends the rows, as if the connection failed partway through reading them
+	rowsErr error
+}
+
+// fakeCall is a statement the fake database was sent.
+type fakeCall struct {
+	query string
+	args  []driver.Value
+}
+
+// fakeDB answers statements with scripted results, in place of MySQL.
+type fakeDB struct {
+	mutex      sync.Mutex
+	queries    []fakeQuery
+	calls      []fakeCall
+	unexpected []string
+}
+
+var fake *fakeDB
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// useFakeDB makes getDB use a fake database that answers the given queries, in order of matching.
+// Call the returned function when done; it fails the test if any statement wasn't expected.
+func useFakeDB(t *testing.T, queries ...fakeQuery) (*fakeDB, func()) {
+	fake = &fakeDB{queries: queries}
+	dbDriver = "fakedb"
+	return fake, func() {
+		dbDriver = "mysql"
+		for _, query := range fake.unexpected {
+			t.Errorf("unexpected statement: %s", query)
+		}
+	}
+}
+
+// concatQueries joins lists of expected queries, for scripts built from shared parts.
+func concatQueries(lists ...[]fakeQuery) []fakeQuery {
+	queries := []fakeQuery{}
+	for _, list := range lists {
+		queries = append(queries, list...)
+	}
+	return queries
+}
+
+// called returns the statements sent that contain match.
+func (db *fakeDB) called(match string) []fakeCall {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	calls := []fakeCall{}
+	for _, call := range db.calls {
+		if strings.Contains(call.query, match) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (db *fakeDB) answer(query string, args []driver.Value) (fakeQuery, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.calls = append(db.calls, fakeCall{query: query, args: args})
+	for i, q := range db.queries {
+		if strings.Contains(query, q.match) {
+			db.queries = append(db.queries[:i:i], db.queries[i+1:]...)
+			return q, q.err
+		}
+	}
+	db.unexpected = append(db.unexpected, query)
+	return fakeQuery{}, fmt.Errorf("unexpected statement: %s", query)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query: query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	q, err := fake.answer(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return fakeResult{q}, nil
+}
+
+type fakeResult struct {
+	q fakeQuery
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.q.insertID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.q.affected, nil }
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	q, err := fake.answer(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	if q.columns == nil {
+		return nil, errors.New("fake query has no columns: " + q.match)
+	}
+	return &fakeRows{columns: q.columns, rows: q.rows, err: q.rowsErr}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 && r.err != nil {
+		return r.err
+	} else if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -1,5 +1,7 @@
 module github.com/wtg/elecnoms
 
+go 1.27.1
+
 require (
 	github.com/go-chi/chi v3.3.2+incompatible
 	github.com/go-sql-driver/mysql v1.3.0
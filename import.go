@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxImportBytes limits the size of a bulk import upload.
+const maxImportBytes = 10 << 20
+
+// importRow is one transcribed nomination line. Sheet identifies the paper sheet the line was on,
+// so that lines from the same sheet end up on the same page; it's only meaningful within the import.
+// Unparsed rows have already been reported as errors, so they aren't checked any further.
+type importRow struct {
+	Row          int    `json:"-"`
+	Unparsed     bool   `json:"-"`
+	CandidateRCS string `json:"candidate_rcs"`
+	OfficeID     string `json:"office_id"`
+	Sheet        string `json:"sheet"`
+	Number       int    `json:"number"`
+	RIN          string `json:"rin"`
+	RcsID        string `json:"rcs"`
+}
+
+// rowError describes a problem (or warning) with one row of an import. Rows are numbered from 1,
+// not counting the CSV header.
+type rowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// importedPage is a page of nominations created by an import.
+type importedPage struct {
+	CandidateRCS string `json:"candidate_rcs"`
+	OfficeID     string `json:"office_id"`
+	Sheet        string `json:"sheet"`
+	Page         int    `json:"page_number"`
+	Nominations  int    `json:"nominations"`
+}
+
+type importResult struct {
+	DryRun   bool           `json:"dry_run"`
+	Pages    []importedPage `json:"pages"`
+	Errors   []rowError     `json:"errors"`
+	Warnings []rowError     `json:"warnings"`
+}
+
+// importGroup is the rows that make up one page.
+type importGroup struct {
+	CandidateRCS string
+	OfficeID     string
+	Sheet        string
+	Rows         []importRow
+}
+
+var importColumns = []string{"candidate_rcs", "office_id", "sheet", "number", "rin", "rcs"}
+
+// parseImportCSV reads import rows from CSV with a header row naming the columns in importColumns,
+// in any order. Rows whose line number isn't a number are reported as errors.
+func parseImportCSV(r io.Reader) ([]importRow, []rowError, error) {
+	rows := []importRow{}
+	errs := []rowError{}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return rows, errs, nil
+	} else if err != nil {
+		return rows, errs, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			return rows, errs, errors.New("missing column " + name)
+		}
+	}
+
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return rows, errs, err
+		}
+		field := func(name string) string {
+			i := columns[name]
+			if i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := importRow{
+			Row:          n,
+			CandidateRCS: field("candidate_rcs"),
+			OfficeID:     field("office_id"),
+			Sheet:        field("sheet"),
+			RIN:          field("rin"),
+			RcsID:        field("rcs"),
+		}
+		row.Number, err = strconv.Atoi(field("number"))
+		if err != nil {
+			errs = append(errs, rowError{Row: n, Field: "number", Message: "Line number must be a number."})
+			row.Unparsed = true
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, errs, nil
+}
+
+// parseImportJSON reads import rows from a JSON list of objects.
+func parseImportJSON(r io.Reader) ([]importRow, error) {
+	rows := []importRow{}
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&rows)
+	for i := range rows {
+		rows[i].Row = i + 1
+	}
+	return rows, err
+}
+
+// groupImportRows groups rows into pages by candidate, office and sheet, in the order each page first
+// appears, and checks each page's lines the same way addNominations does. Unparsed rows are left out.
+func groupImportRows(rows []importRow) ([]importGroup, []rowError) {
+	groups := []importGroup{}
+	errs := []rowError{}
+	index := map[string]int{}
+
+	for _, row := range rows {
+		if row.Unparsed {
+			continue
+		}
+		row.CandidateRCS = strings.ToLower(strings.TrimSpace(row.CandidateRCS))
+		if !rcsIDPattern.MatchString(row.CandidateRCS) {
+			errs = append(errs, rowError{Row: row.Row, Field: "candidate_rcs", Message: "Candidate RCS ID is missing or not in a valid format."})
+		}
+		if _, err := strconv.Atoi(row.OfficeID); err != nil {
+			errs = append(errs, rowError{Row: row.Row, Field: "office_id", Message: "Office ID must be a number."})
+		}
+
+		key := row.CandidateRCS + "\x00" + row.OfficeID + "\x00" + row.Sheet
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, importGroup{CandidateRCS: row.CandidateRCS, OfficeID: row.OfficeID, Sheet: row.Sheet})
+		}
+		groups[i].Rows = append(groups[i].Rows, row)
+	}
+
+	for _, group := range groups {
+		if len(group.Rows) > maxNominationsPerPage {
+			for _, row := range group.Rows[maxNominationsPerPage:] {
+				errs = append(errs, rowError{Row: row.Row, Field: "sheet", Message: "Too many nominations on this sheet; only " + strconv.Itoa(maxNominationsPerPage) + " per page."})
+			}
+		}
+		for _, lineErr := range checkLines(group.nominations()) {
+			errs = append(errs, group.rowError(lineErr))
+		}
+	}
+
+	return groups, errs
+}
+
+// nominations returns the group's rows as nominations, in the same order.
+func (g importGroup) nominations() []Nomination {
+	nominations := []Nomination{}
+	for _, row := range g.Rows {
+		nominations = append(nominations, Nomination{RIN: row.RIN, RcsID: row.RcsID, Number: row.Number})
+	}
+	return nominations
+}
+
+// rowError converts an error about a line of the group's page to one about the import row.
+func (g importGroup) rowError(lineErr lineError) rowError {
+	return rowError{Row: g.Rows[lineErr.Index].Row, Field: lineErr.Field, Message: lineErr.Message}
+}
+
+// writeImportError rejects an import, listing the problems with its rows.
+func writeImportError(w http.ResponseWriter, r *http.Request, errs []rowError) {
+	sendError(w, r, http.StatusUnprocessableEntity, apiError{Code: "invalid_nominations", Message: "Some rows have errors; nothing was imported.", Rows: errs})
+}
+
+// importNominations adds nominations for many candidates at once, from a CSV or JSON file of
+// transcribed nomination lines (see importRow). Lines are grouped into pages by candidate, office and
+// sheet, and each page is added the way addNominations would. Everything is imported in one
+// transaction, so if any row has an error nothing is imported. With dry_run=true, the import is only
+// checked: nothing is written, and the response shows the pages that would be added.
+// Requires authorization, and only admins can use it.
+func importNominations(w http.ResponseWriter, r *http.Request) {
+	// check if this user has permission to do this
+	admin := adminFromContext(r.Context())
+	if !admin {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+	dryRun := r.FormValue("dry_run") == "true"
+
+	// parse rows
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var rows []importRow
+	var errs []rowError
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		rows, errs, err = parseImportCSV(body)
+	case "application/json":
+		rows, err = parseImportJSON(body)
+		errs = []rowError{}
+	default:
+		writeError(w, r, http.StatusUnsupportedMediaType, "", "import must be text/csv or application/json")
+		return
+	}
+	if err != nil {
+		log.Printf("unable to parse import: %s", err.Error())
+		writeError(w, r, http.StatusBadRequest, "", "unable to parse import: "+err.Error())
+		return
+	}
+
+	groups, groupErrs := groupImportRows(rows)
+	errs = append(errs, groupErrs...)
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	// make sure every office can be nominated for
+	officeProblems := map[string]string{}
+	for _, group := range groups {
+		problem, ok := officeProblems[group.OfficeID]
+		if !ok {
+			problem, err = checkOffice(db, group.OfficeID)
+			if err != nil {
+				log.Printf("unable to query database: %s", err.Error())
+				writeStatus(w, r, http.StatusInternalServerError)
+				return
+			}
+			officeProblems[group.OfficeID] = problem
+		}
+		if problem != "" {
+			for _, row := range group.Rows {
+				errs = append(errs, rowError{Row: row.Row, Field: "office_id", Message: problem})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		writeImportError(w, r, errs)
+		return
+	}
+
+	// work out the pages without writing anything, numbering them after the candidate's existing
+	// pages and any added earlier in the import
+	result := importResult{DryRun: dryRun, Pages: []importedPage{}, Errors: []rowError{}, Warnings: []rowError{}}
+	nextPages := map[string]int{}
+	positions := map[string]map[string]string{}
+	for _, group := range groups {
+		key := group.CandidateRCS + "\x00" + group.OfficeID
+		if _, ok := positions[key]; !ok {
+			prevPage, err := lastPage(db, group.CandidateRCS, group.OfficeID)
+			if err == nil {
+				positions[key], err = nominatorPositions(db, group.CandidateRCS, group.OfficeID)
+			}
+			if err != nil {
+				log.Printf("unable to query database: %s", err.Error())
+				writeStatus(w, r, http.StatusInternalServerError)
+				return
+			}
+			nextPages[key] = prevPage + 1
+		}
+		pageNum := nextPages[key]
+		nextPages[key]++
+
+		// duplicates are only warnings, as in addNominations
+		nominations := group.nominations()
+		warnings := append(pageDuplicates(nominations), knownDuplicates(positions[key], nominations)...)
+		for _, warning := range warnings {
+			result.Warnings = append(result.Warnings, group.rowError(warning))
+		}
+		for _, nomination := range nominations {
+			addNominatorPosition(positions[key], nomination.RcsID, pageNum, nomination.Number)
+		}
+
+		result.Pages = append(result.Pages, importedPage{
+			CandidateRCS: group.CandidateRCS,
+			OfficeID:     group.OfficeID,
+			Sheet:        group.Sheet,
+			Page:         pageNum,
+			Nominations:  len(nominations),
+		})
+	}
+
+	if dryRun {
+		log.Printf("dry run import of %d rows into %d pages", len(rows), len(result.Pages))
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.Encode(result)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("unable to begin transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for i, group := range groups {
+		// the page number is decided again as it's added, in case pages were added since
+		pageNum, err := insertPage(r.Context(), tx, group.CandidateRCS, group.OfficeID, group.nominations())
+		if err != nil {
+			log.Printf("unable to insert page: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		err = queuePageWebhooks(tx, group.CandidateRCS, group.OfficeID, pageNum)
+		if err != nil {
+			log.Printf("unable to queue webhooks: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		result.Pages[i].Page = pageNum
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("unable to commit transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("imported %d rows into %d pages", len(rows), len(result.Pages))
+	for _, page := range result.Pages {
+		publishPage(db, page.CandidateRCS, page.OfficeID, page.Page)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(result)
+}
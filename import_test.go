@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	input := "rcs,rin,number,sheet,office_id,candidate_rcs\n" +
+		"kochms,999,1,A,3,lyonj4\n" +
+		"smithj, 123 ,two,A,3,lyonj4\n"
+
+	rows, errs, err := parseImportCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expectedRows := []importRow{
+		importRow{Row: 1, CandidateRCS: "lyonj4", OfficeID: "3", Sheet: "A", Number: 1, RIN: "999", RcsID: "kochms"},
+		importRow{Row: 2, Unparsed: true, CandidateRCS: "lyonj4", OfficeID: "3", Sheet: "A", Number: 0, RIN: "123", RcsID: "smithj"},
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("expected %+v, got %+v", expectedRows, rows)
+	}
+	expectedErrs := []rowError{rowError{Row: 2, Field: "number", Message: "Line number must be a number."}}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf("expected %+v, got %+v", expectedErrs, errs)
+	}
+
+	_, _, err = parseImportCSV(strings.NewReader("rcs,rin,number\nkochms,999,1\n"))
+	if err == nil {
+		t.Errorf("expected error for missing columns")
+	}
+}
+
+func TestGroupImportRows(t *testing.T) {
+	rows := []importRow{
+		importRow{Row: 1, CandidateRCS: "LyonJ4", OfficeID: "3", Sheet: "A", Number: 1, RIN: "999", RcsID: "kochms"},
+		importRow{Row: 2, CandidateRCS: "kochms", OfficeID: "3", Sheet: "A", Number: 1, RIN: "777", RcsID: "lyonj4"},
+		importRow{Row: 3, CandidateRCS: "lyonj4", OfficeID: "3", Sheet: "A", Number: 1, RIN: "12", RcsID: "smithj"},
+		importRow{Row: 4, CandidateRCS: "lyonj4", OfficeID: "3", Sheet: "B", Number: 1, RIN: "123", RcsID: "smithj"},
+		importRow{Row: 5, CandidateRCS: "", OfficeID: "x", Sheet: "A", Number: 1, RIN: "123", RcsID: "doej"},
+		// already reported when it was parsed
+		importRow{Row: 6, Unparsed: true, CandidateRCS: "lyonj4", OfficeID: "3", Sheet: "B", Number: 0, RIN: "123", RcsID: "doej"},
+	}
+
+	groups, errs := groupImportRows(rows)
+
+	sizes := []int{}
+	for _, group := range groups {
+		sizes = append(sizes, len(group.Rows))
+	}
+	if !reflect.DeepEqual(sizes, []int{2, 1, 1, 1}) {
+		t.Errorf("expected page sizes [2 1 1 1], got %v", sizes)
+	}
+	if groups[0].CandidateRCS != "lyonj4" || groups[0].Sheet != "A" {
+		t.Errorf("expected first page to be lyonj4 sheet A, got %+v", groups[0])
+	}
+
+	expectedErrs := []rowError{
+		rowError{Row: 5, Field: "candidate_rcs", Message: "Candidate RCS ID is missing or not in a valid format."},
+		rowError{Row: 5, Field: "office_id", Message: "Office ID must be a number."},
+		rowError{Row: 3, Field: "rin", Message: "Partial RIN must be exactly three digits."},
+		rowError{Row: 3, Field: "number", Message: "Line number 1 is used more than once on this page."},
+	}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf("expected %+v, got %+v", expectedErrs, errs)
+	}
+}
+
+func TestImportNominations(t *testing.T) {
+	office := fakeQuery{match: "FROM offices WHERE office_id = ?", columns: []string{"nominations_required", "disabled"}, rows: [][]driver.Value{[]driver.Value{int64(50), int64(0)}}}
+	lastPage := fakeQuery{match: "SELECT COALESCE(MAX(page), 0)", columns: []string{"page"}, rows: [][]driver.Value{[]driver.Value{int64(2)}}}
+	positions := fakeQuery{match: "SELECT nomination_rcs_id, page, number", columns: []string{"nomination_rcs_id", "page", "number"}, rows: [][]driver.Value{[]driver.Value{"doej", int64(1), int64(4)}}}
+	validCount := fakeQuery{match: "SELECT COUNT(*) FROM nominations", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(0)}}}
+	// inserted is what adding a page with some nominations does, including queueing its webhooks
+	inserted := func(nominations int) []fakeQuery {
+		queries := []fakeQuery{}
+		for i := 0; i < nominations; i++ {
+			queries = append(queries, fakeQuery{match: "INSERT INTO nominations", affected: 1})
+		}
+		return append(queries,
+			fakeQuery{match: "INSERT INTO audit_log", affected: 1},
+			validCount,
+			fakeQuery{match: "SELECT nominations_required FROM offices", columns: []string{"nominations_required"}, rows: [][]driver.Value{[]driver.Value{int64(50)}}},
+			fakeQuery{match: "INSERT INTO webhook_deliveries", affected: 1},
+		)
+	}
+	input := "candidate_rcs,office_id,sheet,number,rin,rcs\n" +
+		"lyonj4,3,A,1,123,doej\n" +
+		"lyonj4,3,B,1,456,smithj\n" +
+		"lyonj4,3,B,2,789,kochms\n"
+
+	type testCase struct {
+		expected int
+		target   string
+		body     string
+		queries  []fakeQuery
+		// pages are the page numbers expected, and warnings the rows warned about
+		pages    []int
+		warnings []int
+		// errors are the rows expected in the error, if any
+		errors []rowError
+		// inserts is the number of nominations expected to be added
+		inserts int
+	}
+	cases := []testCase{
+		// a line number that isn't a number is only reported once
+		testCase{
+			expected: http.StatusUnprocessableEntity, target: "/import", body: "candidate_rcs,office_id,sheet,number,rin,rcs\nlyonj4,3,A,two,123,doej\n",
+			errors: []rowError{rowError{Row: 1, Field: "number", Message: "Line number must be a number."}},
+		},
+		// a dry run plans the pages after the existing ones and the earlier sheets, without writing
+		testCase{
+			expected: http.StatusOK, target: "/import?dry_run=true", body: input + "lyonj4,3,C,1,111,smithj\n",
+			queries: []fakeQuery{office, lastPage, positions},
+			pages:   []int{3, 4, 5}, warnings: []int{1, 4},
+		},
+		testCase{
+			expected: http.StatusOK, target: "/import", body: input,
+			queries: concatQueries(
+				[]fakeQuery{office, lastPage, positions, lastPage},
+				inserted(1),
+				[]fakeQuery{fakeQuery{match: "SELECT COALESCE(MAX(page), 0)", columns: []string{"page"}, rows: [][]driver.Value{[]driver.Value{int64(3)}}}},
+				inserted(2),
+				// published once committed
+				[]fakeQuery{validCount, validCount},
+			),
+			pages: []int{3, 4}, warnings: []int{1}, inserts: 3,
+		},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, c.target, strings.NewReader(c.body)).WithContext(userContext("admin1", true))
+		r.Header.Set("Content-Type", "text/csv")
+		importNominations(w, r)
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expected, w.Code, w.Body.String())
+			continue
+		}
+		if inserts := len(db.called("INSERT INTO nominations")); inserts != c.inserts {
+			t.Errorf("%s: expected %d nominations added, got %d", c.target, c.inserts, inserts)
+		}
+		if c.errors != nil {
+			body := struct {
+				Error apiError `json:"error"`
+			}{}
+			err := json.NewDecoder(w.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != "invalid_nominations" || !reflect.DeepEqual(body.Error.Rows, c.errors) {
+				t.Errorf("%s: expected errors %+v, got %+v", c.target, c.errors, body.Error)
+			}
+			continue
+		}
+
+		result := importResult{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := []int{}
+		for _, page := range result.Pages {
+			pages = append(pages, page.Page)
+		}
+		warnings := []int{}
+		for _, warning := range result.Warnings {
+			warnings = append(warnings, warning.Row)
+		}
+		if !reflect.DeepEqual(pages, c.pages) || !reflect.DeepEqual(warnings, c.warnings) {
+			t.Errorf("%s: expected pages %v and warnings on rows %v, got %+v", c.target, c.pages, c.warnings, result)
+		}
+	}
+}
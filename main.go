@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -25,17 +27,29 @@ type Nomination struct {
 }
 
 type NominationPage struct {
-	Number      int          `json:"page_number"`
-	Nominations []Nomination `json:"nominations"`
-	OfficeID    int          `json:"office_id"`
-	Submitted   time.Time    `json:"submitted"`
+	Number       int          `json:"page_number"`
+	Nominations  []Nomination `json:"nominations"`
+	CandidateRCS string       `json:"candidate_rcs"`
+	OfficeID     int          `json:"office_id"`
+	Submitted    time.Time    `json:"submitted"`
+}
+
+// pageNomination is a nomination along with what identifies the page it's on.
+type pageNomination struct {
+	Nomination
+	CandidateRCS string    `json:"candidate_rcs"`
+	OfficeID     int       `json:"office_id"`
+	Submitted    time.Time `json:"submitted"`
 }
 
 var activeElectionQuery = "(SELECT value FROM configurations WHERE `key` = 'active_election_id')"
 
+// dbDriver is the database/sql driver getDB uses. Tests replace it with a fake.
+var dbDriver = "mysql"
+
 // getDB returns a database connection. The caller is responsible for closing it.
 func getDB() (*sql.DB, error) {
-	db, err := sql.Open("mysql", os.Getenv("DATABASE_URL")+"?parseTime=true")
+	db, err := sql.Open(dbDriver, os.Getenv("DATABASE_URL")+"?parseTime=true")
 	if err != nil {
 		log.Printf("unable to open database: %s", err.Error())
 		return nil, err
@@ -81,6 +95,52 @@ func contains(slice []string, str string) bool {
 	return false
 }
 
+// canActForCandidate returns whether the user on the context may act on behalf of a candidate:
+// admins, the candidate herself, and her assistants.
+func canActForCandidate(ctx context.Context, rcs string) (bool, error) {
+	casUser := casUserFromContext(ctx)
+	if adminFromContext(ctx) || casUser == rcs {
+		return true, nil
+	}
+	assistants, err := getCandidateAssistants(rcs)
+	if err != nil {
+		return false, err
+	}
+	return contains(assistants, casUser), nil
+}
+
+// groupPages sorts nominations into pages by candidate, office and page number. Pages are in the
+// order their first nomination appears, and nominations keep their order within each page. A page's
+// submission date is that of its latest nomination, as in browseNominations.
+func groupPages(nominations []pageNomination) []NominationPage {
+	type pageKey struct {
+		candidate string
+		office    int
+		page      int
+	}
+	pages := []NominationPage{}
+	index := map[pageKey]int{}
+	for _, nomination := range nominations {
+		key := pageKey{nomination.CandidateRCS, nomination.OfficeID, nomination.Page}
+		i, ok := index[key]
+		if !ok {
+			i = len(pages)
+			index[key] = i
+			pages = append(pages, NominationPage{
+				Number:       nomination.Page,
+				Nominations:  []Nomination{},
+				CandidateRCS: nomination.CandidateRCS,
+				OfficeID:     nomination.OfficeID,
+			})
+		}
+		pages[i].Nominations = append(pages[i].Nominations, nomination.Nomination)
+		if nomination.Submitted.After(pages[i].Submitted) {
+			pages[i].Submitted = nomination.Submitted
+		}
+	}
+	return pages
+}
+
 // listNominations returns a list of nomination pages for a given RCS ID.
 // If an office ID is provided, it only lists nominations for that office.
 // Authorization is required, and people with permission are admins, the candidate with the specified RCS ID, and her assistants.
@@ -89,23 +149,19 @@ func listNominations(w http.ResponseWriter, r *http.Request) {
 	rcs := strings.ToLower(r.FormValue("rcs"))
 	if rcs == "" {
 		log.Print("missing rcs")
-		http.Error(w, "missing rcs", http.StatusUnprocessableEntity)
+		writeMissing(w, r, "rcs", "missing rcs")
 		return
 	}
 
 	// check if this user has permission to do this
-	admin := adminFromContext(r.Context())
-	casUser := casUserFromContext(r.Context())
-	// find assistants and see if this user is one
-	assistants, err := getCandidateAssistants(rcs)
+	allowed, err := canActForCandidate(r.Context(), rcs)
 	if err != nil {
 		log.Printf("unable to get candidate assistants: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
-	assistant := contains(assistants, casUser)
-	if !admin && casUser != rcs && !assistant {
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	if !allowed {
+		writeStatus(w, r, http.StatusUnauthorized)
 		return
 	}
 
@@ -113,7 +169,7 @@ func listNominations(w http.ResponseWriter, r *http.Request) {
 	db, err := getDB()
 	if err != nil {
 		log.Printf("unable to get database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 	defer db.Close()
@@ -136,45 +192,26 @@ func listNominations(w http.ResponseWriter, r *http.Request) {
 	}
 	if err != nil {
 		log.Printf("unable to query database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 
-	// sort nominations into pages (map of office ID to page number to NominationPage)
-	offices := map[int]map[int]NominationPage{}
+	defer rows.Close()
+	nominations := []pageNomination{}
 	for rows.Next() {
-		nomination := Nomination{}
-		var nomOfficeID int
-		var date time.Time
-		err = rows.Scan(&nomination.ID, &nomination.RIN, &nomination.RcsID, &nomination.Valid, &nomination.Page, &nomOfficeID, &date, &nomination.Number)
+		nomination := pageNomination{CandidateRCS: rcs}
+		err = rows.Scan(&nomination.ID, &nomination.RIN, &nomination.RcsID, &nomination.Valid, &nomination.Page, &nomination.OfficeID, &nomination.Submitted, &nomination.Number)
 		if err != nil {
 			log.Printf("unable to scan: %s", err.Error())
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeStatus(w, r, http.StatusInternalServerError)
 			return
 		}
-
-		// add nomination to page
-		if _, ok := offices[nomOfficeID]; !ok {
-			offices[nomOfficeID] = map[int]NominationPage{}
-		}
-		page := offices[nomOfficeID][nomination.Page]
-		page.Number = nomination.Page
-		page.Nominations = append(page.Nominations, nomination)
-		page.OfficeID = nomOfficeID
-		page.Submitted = date
-		offices[nomOfficeID][nomination.Page] = page
-	}
-
-	// flatten pages from map to list
-	flat := []NominationPage{}
-	for _, pages := range offices {
-		for _, page := range pages {
-			flat = append(flat, page)
-		}
+		nominations = append(nominations, nomination)
 	}
 
-	// return them in ascending page number order
-	sort.Slice(flat, func(i, j int) bool {
+	// sort nominations into pages, and return them in ascending page number order
+	flat := groupPages(nominations)
+	sort.SliceStable(flat, func(i, j int) bool {
 		return flat[i].Number < flat[j].Number
 	})
 
@@ -184,27 +221,27 @@ func listNominations(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(flat)
 }
 
+// addNominations adds a new page of nominations for a given RCS ID and office. Lines are checked
+// for format before anything is inserted, and the response lists the new page number along with
+// warnings about duplicate nominators.
+// Authorization is required, and people with permission are admins, the candidate with the specified RCS ID, and her assistants.
 func addNominations(w http.ResponseWriter, r *http.Request) {
 	// extract/validate candidate RCS ID
 	rcs := strings.ToLower(r.FormValue("rcs"))
 	if rcs == "" {
 		log.Print("missing RCS")
-		http.Error(w, "missing RCS", http.StatusUnprocessableEntity)
+		writeMissing(w, r, "rcs", "missing RCS")
 		return
 	}
 	// check if this user has permission to do this
-	admin := adminFromContext(r.Context())
-	casUser := casUserFromContext(r.Context())
-	// find assistants and see if this user is one
-	assistants, err := getCandidateAssistants(rcs)
+	allowed, err := canActForCandidate(r.Context(), rcs)
 	if err != nil {
 		log.Printf("unable to get candidate assistants: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
-	assistant := contains(assistants, casUser)
-	if !admin && casUser != rcs && !assistant {
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	if !allowed {
+		writeStatus(w, r, http.StatusUnauthorized)
 		return
 	}
 
@@ -212,7 +249,7 @@ func addNominations(w http.ResponseWriter, r *http.Request) {
 	office := r.FormValue("office")
 	if office == "" {
 		log.Print("missing office")
-		http.Error(w, "missing office", http.StatusUnprocessableEntity)
+		writeMissing(w, r, "office", "missing office")
 		return
 	}
 
@@ -222,55 +259,83 @@ func addNominations(w http.ResponseWriter, r *http.Request) {
 	err = dec.Decode(&nominations)
 	if err != nil {
 		log.Printf("unable to decode JSON: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeStatus(w, r, http.StatusBadRequest)
 		return
 	}
 	fmt.Printf("%+v", nominations)
 
 	// sanity check
-	if len(nominations) > 25 {
-		http.Error(w, "too many nominations; only 25 per page", http.StatusUnprocessableEntity)
+	if len(nominations) > maxNominationsPerPage {
+		writeError(w, r, http.StatusUnprocessableEntity, "", "too many nominations; only 25 per page")
+		return
+	}
+	if lineErrs := checkLines(nominations); len(lineErrs) > 0 {
+		writeSubmissionError(w, r, "", "invalid nominations", lineErrs)
 		return
 	}
 
-	// start database transaction
 	db, err := getDB()
 	if err != nil {
 		log.Printf("unable to get database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 	defer db.Close()
+
+	// make sure the office can be nominated for
+	officeProblem, err := checkOffice(db, office)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if officeProblem != "" {
+		writeSubmissionError(w, r, "office", officeProblem, nil)
+		return
+	}
+
+	// start database transaction
 	tx, err := db.Begin()
 	if err != nil {
 		log.Printf("unable to begin transaction: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 	defer tx.Rollback()
 
-	// figure out the highest existing page number and add 1 to it
-	row := tx.QueryRow("SELECT COALESCE(MAX(page), 0) FROM nominations WHERE rcs_id = ? and office_id = ? and election_id = "+activeElectionQuery, rcs, office)
-	var prevPage int
-	err = row.Scan(&prevPage)
+	// duplicates are only warnings; the lines are still stored as pending for an admin to decide on
+	warnings := pageDuplicates(nominations)
+	existingWarnings, err := existingDuplicates(tx, rcs, office, nominations)
 	if err != nil {
 		log.Printf("unable to query database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
-	pageNum := prevPage + 1
-
-	// loop over provided nominations and insert
-	for _, nomination := range nominations {
+	warnings = append(warnings, existingWarnings...)
 
-		_, err = tx.Exec("INSERT INTO nominations (rcs_id, office_id, nomination_partial_rin, nomination_rcs_id, page, number, election_id) VALUES (?, ?, ?, ?, ?, ?, "+activeElectionQuery+");", rcs, office, nomination.RIN, strings.ToLower(nomination.RcsID), pageNum, nomination.Number)
-		if err != nil {
-			log.Printf("unable to query database: %s", err.Error())
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
+	pageNum, err := insertPage(r.Context(), tx, rcs, office, nominations)
+	if err != nil {
+		log.Printf("unable to insert page: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	err = queuePageWebhooks(tx, rcs, office, pageNum)
+	if err != nil {
+		log.Printf("unable to queue webhooks: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("unable to commit transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
 	}
-	tx.Commit()
+	publishPage(db, rcs, office, pageNum)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(submissionResult{Page: pageNum, Warnings: warnings})
 }
 
 // modifyNomination updates an existing nomination to match the provided nomination.
@@ -281,7 +346,7 @@ func modifyNomination(w http.ResponseWriter, r *http.Request) {
 	// check if this user has permission to do this
 	admin := adminFromContext(r.Context())
 	if !admin {
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		writeStatus(w, r, http.StatusUnauthorized)
 		return
 	}
 
@@ -289,7 +354,7 @@ func modifyNomination(w http.ResponseWriter, r *http.Request) {
 	nomID := r.FormValue("nomination")
 	if nomID == "" {
 		log.Print("missing nomination ID")
-		http.Error(w, "missing nomination ID", http.StatusUnprocessableEntity)
+		writeMissing(w, r, "nomination", "missing nomination ID")
 		return
 	}
 
@@ -299,7 +364,7 @@ func modifyNomination(w http.ResponseWriter, r *http.Request) {
 	err := dec.Decode(&nomination)
 	if err != nil {
 		log.Printf("unable to decode JSON: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeStatus(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -307,28 +372,149 @@ func modifyNomination(w http.ResponseWriter, r *http.Request) {
 	db, err := getDB()
 	if err != nil {
 		log.Printf("unable to get database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("unable to begin transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// remember the nomination's validity, to tell whether this changes it
+	var oldValid sql.NullBool
+	var candidate string
+	row := tx.QueryRow("SELECT valid, rcs_id FROM nominations WHERE nomination_id = ? FOR UPDATE", nomination.ID)
+	err = row.Scan(&oldValid, &candidate)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	validChanged := oldValid.Valid != (nomination.Valid != nil) || (nomination.Valid != nil && oldValid.Bool != *nomination.Valid)
+	previousCount := 0
+	if validChanged {
+		previousCount, err = lockValidCount(tx, nomination.ID)
+		if err != nil {
+			log.Printf("unable to query database: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// update nomination in database
-	_, err = db.Exec("UPDATE nominations SET nomination_partial_rin = ?, nomination_rcs_id = ?, page = ?, valid = ?, number = ? WHERE nomination_id = ?;", nomination.RIN, nomination.RcsID, nomination.Page, nomination.Valid, nomination.Number, nomination.ID)
+	_, err = tx.Exec("UPDATE nominations SET nomination_partial_rin = ?, nomination_rcs_id = ?, page = ?, valid = ?, number = ? WHERE nomination_id = ?;", nomination.RIN, nomination.RcsID, nomination.Page, nomination.Valid, nomination.Number, nomination.ID)
 	if err != nil {
 		log.Printf("unable to query database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
+
+	details, err := json.Marshal(nomination)
+	if err != nil {
+		log.Printf("unable to encode JSON: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	err = recordAudit(r.Context(), tx, auditEntry{
+		Action:       auditModifyNomination,
+		NominationID: nomination.ID,
+		Details:      string(details),
+	})
+	if err != nil {
+		log.Printf("unable to record audit entry: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if nomination.Valid != nil {
+		err = resolveReview(tx, nomination.ID)
+		if err != nil {
+			log.Printf("unable to resolve review: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	err = queueNominationWebhooks(tx, nomination.ID, validChanged, previousCount)
+	if err != nil {
+		log.Printf("unable to queue webhooks: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if validChanged && nomination.Valid != nil && !*nomination.Valid && candidate != "" {
+		err = queueDigest(tx, candidate)
+		if err != nil {
+			log.Printf("unable to queue digest: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("unable to commit transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	publishNomination(db, eventNominationModified, nomination.ID, validChanged)
 }
 
-func main() {
+// newRouter returns the router with every endpoint registered. Development endpoints are only
+// registered in dev mode.
+func newRouter() chi.Router {
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
 	r.Use(authenticate)
-	r.Get("/", listNominations)
-	r.Post("/", addNominations)
-	r.Put("/", modifyNomination)
-	r.Get("/validate", validateNomination)
-	r.Get("/counts", nominationCounts)
+	// unknown routes get the same error envelope as everything else
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusNotFound)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusMethodNotAllowed)
+	})
+	r.Get("/openapi.json", openAPIDocument)
+	r.With(requireScope(scopeReadNominations)).Get("/", listNominations)
+	r.With(requireScope(scopeWrite)).Post("/", addNominations)
+	r.With(requireScope(scopeWrite)).Put("/", modifyNomination)
+	r.With(requireScope(scopeReadNominations)).Get("/nominations", browseNominations)
+	r.With(requireScope(scopeReadNominations)).Get("/nominator", nominatorLookup)
+	r.With(requireScope(scopeReadNominations)).Get("/nominator/me", ownNominations)
+	r.With(requireScope(scopeValidate)).Get("/validate", validateNomination)
+	r.With(requireScope(scopeReadCounts)).Get("/counts", nominationCounts)
+	r.With(requireScope(scopeReadCounts)).Get("/events", streamEvents(hub))
+	r.With(requireScope(scopeValidate)).Get("/review", reviewQueue)
+	r.With(requireScope(scopeValidate)).Post("/review/claim", claimNomination)
+	r.With(requireScope(scopeValidate)).Post("/review/release", releaseNomination)
+	r.With(requireScope(scopeReadNominations)).Get("/appeals", listAppeals)
+	r.With(requireScope(scopeWrite)).Post("/appeals", fileAppeal)
+	r.With(requireScope(scopeWrite)).Put("/appeals", resolveAppeal)
+	r.With(requireScope(scopeReadNominations)).Get("/export", exportNominations)
+	r.With(requireScope(scopeWrite)).Post("/import", importNominations)
+	r.With(requireScope(scopeReadNominations)).Get("/attachments", downloadAttachment)
+	r.With(requireScope(scopeWrite)).Post("/attachments", uploadAttachment)
+	r.Post("/logout", logout)
+	r.Get("/tokens", listTokens)
+	r.Post("/tokens", createToken)
+	r.Delete("/tokens", revokeToken)
+	r.Get("/webhooks", listWebhooks)
+	r.Post("/webhooks", createWebhook)
+	r.Put("/webhooks", updateWebhook)
+	r.Delete("/webhooks", deleteWebhook)
+	r.Get("/webhooks/deliveries", listWebhookDeliveries)
+
+	if devMode() {
+		log.Print("DEV_MODE is set; anyone can log in as anyone at /dev/login")
+		r.Get("/dev/login", devLogin)
+	}
+
+	return r
+}
+
+func main() {
+	r := newRouter()
+	startWebhooks()
+	startNotifier()
 
 	listenURL := os.Getenv("LISTEN_URL")
 	if listenURL == "" {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain runs the tests as of a fixed date, so that tests of cohorts don't depend on the year.
+func TestMain(m *testing.M) {
+	clock = func() time.Time {
+		return time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC)
+	}
+	os.Exit(m.Run())
+}
+
+// userContext returns the context of a request from a user logged in with a session.
+func userContext(rcs string, admin bool) context.Context {
+	ctx := unauthenticatedContext(context.Background())
+	ctx = context.WithValue(ctx, casUserKey, rcs)
+	ctx = context.WithValue(ctx, adminKey, admin)
+	ctx = context.WithValue(ctx, authenticatedKey, true)
+	return ctx
+}
+
+func TestListNominations(t *testing.T) {
+	first := time.Date(2019, time.February, 1, 12, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	nominationColumns := []string{"nomination_id", "nomination_partial_rin", "nomination_rcs_id", "valid", "page", "office_id", "date", "number"}
+
+	type testCase struct {
+		expected int
+		ctx      context.Context
+		target   string
+		queries  []fakeQuery
+	}
+	cases := []testCase{
+		testCase{expected: http.StatusUnprocessableEntity, ctx: userContext("lyonj4", false), target: "/?office=3"},
+		testCase{
+			expected: http.StatusUnauthorized, ctx: userContext("smithj", false), target: "/?rcs=lyonj4",
+			queries: []fakeQuery{fakeQuery{match: "FROM assistants", columns: []string{"rcs_id"}, rows: [][]driver.Value{}}},
+		},
+		testCase{
+			expected: http.StatusOK, ctx: userContext("smithj", false), target: "/?rcs=lyonj4&office=3",
+			queries: []fakeQuery{
+				fakeQuery{match: "FROM assistants", columns: []string{"rcs_id"}, rows: [][]driver.Value{[]driver.Value{"smithj"}}},
+				fakeQuery{match: "FROM nominations WHERE rcs_id = ? AND office_id = ?", columns: nominationColumns, rows: [][]driver.Value{
+					[]driver.Value{int64(4), "456", "smithj", nil, int64(2), int64(3), first, int64(1)},
+					[]driver.Value{int64(1), "123", "doej", int64(1), int64(1), int64(3), first, int64(1)},
+					[]driver.Value{int64(2), "234", "roej", int64(0), int64(1), int64(3), last, int64(2)},
+				}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		_, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		listNominations(w, httptest.NewRequest(http.MethodGet, c.target, nil).WithContext(c.ctx))
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expected, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		pages := []NominationPage{}
+		err := json.NewDecoder(w.Body).Decode(&pages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != 2 || pages[0].Number != 1 || pages[1].Number != 2 {
+			t.Fatalf("%s: expected pages 1 and 2, got %+v", c.target, pages)
+		}
+		// a page was submitted when its last nomination was
+		if !pages[0].Submitted.Equal(last) || len(pages[0].Nominations) != 2 {
+			t.Errorf("%s: expected page 1 submitted at %s, got %+v", c.target, last, pages[0])
+		}
+	}
+}
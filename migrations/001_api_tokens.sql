@@ -0,0 +1,13 @@
+-- API tokens for scripts and other services. Only a SHA-256 hash of each token is stored;
+-- the token itself is shown once, when an admin creates it.
+CREATE TABLE IF NOT EXISTS api_tokens (
+	token_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	token_hash CHAR(64) NOT NULL,
+	scopes VARCHAR(255) NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revoked_at DATETIME NULL,
+	PRIMARY KEY (token_id),
+	UNIQUE KEY (token_hash)
+);
@@ -0,0 +1,16 @@
+-- Record of changes made through elecnoms. actor_rcs_id is who really made the change, and
+-- effective_rcs_id is who they were acting as, which differ when an admin is impersonating someone.
+CREATE TABLE IF NOT EXISTS audit_log (
+	audit_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	actor_rcs_id VARCHAR(255) NOT NULL,
+	effective_rcs_id VARCHAR(255) NOT NULL,
+	impersonated BOOLEAN NOT NULL DEFAULT FALSE,
+	action VARCHAR(64) NOT NULL,
+	candidate_rcs_id VARCHAR(255) NULL,
+	nomination_id INT NULL,
+	details TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (audit_id),
+	KEY (nomination_id),
+	KEY (candidate_rcs_id)
+);
@@ -0,0 +1,8 @@
+-- Election rules that apply to individual offices. Offices without a row have no extra rules.
+-- max_candidates_per_nominator limits how many different candidates one student may nominate
+-- for the office (e.g. one per seat); NULL means no limit.
+CREATE TABLE IF NOT EXISTS office_rules (
+	office_id INT NOT NULL,
+	max_candidates_per_nominator INT NULL,
+	PRIMARY KEY (office_id)
+);
@@ -0,0 +1,8 @@
+-- Election rules that apply to a whole election. Elections without a row use the defaults.
+-- self_nomination is how a candidate nominating themselves is treated: 'problem' makes the
+-- nomination invalid, and 'warning' only points it out to admins.
+CREATE TABLE IF NOT EXISTS election_rules (
+	election_id INT NOT NULL,
+	self_nomination VARCHAR(16) NOT NULL DEFAULT 'problem',
+	PRIMARY KEY (election_id)
+);
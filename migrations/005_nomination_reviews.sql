@@ -0,0 +1,14 @@
+-- The latest automatic validation result for each nomination, and who (if anyone) is reviewing it.
+-- problems and warnings are JSON lists of messages; problem_codes is a comma-separated list of
+-- their codes for filtering. Nominations are flagged for manual review when validation has warnings.
+CREATE TABLE IF NOT EXISTS nomination_reviews (
+	nomination_id INT NOT NULL,
+	flagged BOOLEAN NOT NULL DEFAULT FALSE,
+	problems TEXT NOT NULL,
+	warnings TEXT NOT NULL,
+	problem_codes VARCHAR(255) NOT NULL DEFAULT '',
+	checked_at DATETIME NULL,
+	claimed_by VARCHAR(255) NULL,
+	claimed_at DATETIME NULL,
+	PRIMARY KEY (nomination_id)
+);
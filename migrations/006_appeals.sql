@@ -0,0 +1,26 @@
+-- Appeals filed by candidates (or their assistants) against nominations marked invalid.
+-- status is 'pending' until an admin accepts or denies the appeal.
+CREATE TABLE IF NOT EXISTS appeals (
+	appeal_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	nomination_id INT NOT NULL,
+	filed_by VARCHAR(255) NOT NULL,
+	note TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	resolved_by VARCHAR(255) NULL,
+	resolution_note TEXT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resolved_at DATETIME NULL,
+	PRIMARY KEY (appeal_id),
+	KEY (nomination_id)
+);
+
+-- Audit entries about appeals link to them. MySQL has no ADD COLUMN IF NOT EXISTS, so the column is
+-- only added when information_schema says it's missing, which lets this file run more than once.
+SET @add_appeal_id = (SELECT IF(COUNT(*) = 0,
+	'ALTER TABLE audit_log ADD COLUMN appeal_id INT UNSIGNED NULL AFTER nomination_id',
+	'DO 0')
+	FROM information_schema.columns
+	WHERE table_schema = DATABASE() AND table_name = 'audit_log' AND column_name = 'appeal_id');
+PREPARE add_appeal_id FROM @add_appeal_id;
+EXECUTE add_appeal_id;
+DEALLOCATE PREPARE add_appeal_id;
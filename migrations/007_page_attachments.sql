@@ -0,0 +1,17 @@
+-- Scans of signed paper nomination sheets, one per page. The file itself is kept in the blob store
+-- (see blobstore.go) under blob_key.
+CREATE TABLE IF NOT EXISTS page_attachments (
+	attachment_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	candidate_rcs_id VARCHAR(255) NOT NULL,
+	office_id INT NOT NULL,
+	page INT NOT NULL,
+	election_id INT NOT NULL,
+	blob_key VARCHAR(64) NOT NULL,
+	content_type VARCHAR(64) NOT NULL,
+	size INT UNSIGNED NOT NULL,
+	filename VARCHAR(255) NOT NULL,
+	uploaded_by VARCHAR(255) NOT NULL,
+	uploaded_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (attachment_id),
+	UNIQUE KEY (election_id, candidate_rcs_id, office_id, page)
+);
@@ -0,0 +1,32 @@
+-- Outbound webhooks, and a log of every delivery to them. events is a comma-separated list of the
+-- events a webhook is sent. The secret signs payloads, so it's stored as is and shown once, when an
+-- admin creates the webhook; access to this table should be limited accordingly.
+CREATE TABLE IF NOT EXISTS webhooks (
+	webhook_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(255) NOT NULL,
+	events VARCHAR(255) NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT true,
+	created_by VARCHAR(255) NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (webhook_id)
+);
+
+-- Deliveries double as the queue the delivery worker works through: next_attempt_at is when a
+-- pending delivery is next due, and is NULL once it's been delivered or has run out of attempts.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	delivery_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	webhook_id INT UNSIGNED NOT NULL,
+	event VARCHAR(64) NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	status_code INT NULL,
+	error TEXT NULL,
+	delivered BOOLEAN NOT NULL DEFAULT false,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_attempt_at DATETIME NULL,
+	next_attempt_at DATETIME NULL,
+	PRIMARY KEY (delivery_id),
+	KEY (webhook_id, created_at),
+	KEY (next_attempt_at)
+);
@@ -0,0 +1,8 @@
+-- Candidates due an email digest of their invalid nominations. A row is added when a nomination is
+-- marked invalid, and removed when the digest is sent at due_at.
+CREATE TABLE IF NOT EXISTS pending_digests (
+	candidate_rcs_id VARCHAR(255) NOT NULL,
+	due_at DATETIME NOT NULL,
+	PRIMARY KEY (candidate_rcs_id),
+	KEY (due_at)
+);
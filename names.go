@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// diacritics maps accented lowercase letters to their unaccented equivalents, so that names typed
+// without accents still match Institute records that have them (and vice versa).
+var diacritics = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae",
+	'ç': "c", 'ć': "c", 'č': "c", 'ĉ': "c",
+	'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ģ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ķ': "k",
+	'ł': "l", 'ľ': "l", 'ļ': "l", 'ĺ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n", 'ņ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'œ': "oe",
+	'ř': "r", 'ŕ': "r",
+	'ß': "ss", 'ś': "s", 'š': "s", 'ş': "s", 'ș': "s",
+	'ť': "t", 'ţ': "t", 'ț': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// nameTokens lowercases a name, strips diacritics and punctuation, and splits it into words.
+// Hyphenated names are split into their parts, and apostrophes are dropped ("O'Brien" is "obrien").
+func nameTokens(name string) []string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if folded, ok := diacritics[r]; ok {
+			b.WriteString(folded)
+		} else if r >= 'a' && r <= 'z' {
+			b.WriteRune(r)
+		} else if r == '\'' || r == '’' || r == '.' {
+			continue
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Fields(b.String())
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a string, b string) int {
+	ar := []rune(a)
+	br := []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// wordSimilarity scores how alike two normalized words are, from 0 to 1. Besides spelling
+// differences, it allows for initials ("j" for "joseph") and shortened names ("joe" for "joseph").
+func wordSimilarity(a string, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	if len(a) == 1 || len(b) == 1 {
+		if a[0] == b[0] {
+			return 0.8
+		}
+		return 0
+	}
+	if len(a) >= 3 && len(b) >= 3 && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a)) {
+		return 0.9
+	}
+	longest := len([]rune(a))
+	if l := len([]rune(b)); l > longest {
+		longest = l
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// bestSimilarity returns the highest similarity between word and any of the candidates.
+func bestSimilarity(word string, candidates []string) float64 {
+	best := 0.0
+	for _, candidate := range candidates {
+		if s := wordSimilarity(word, candidate); s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+// nameConfidence scores how likely it is that a name written on a nomination belongs to the
+// person with the given Institute records, from 0 to 1. People may go by their middle name,
+// leave out middle names, write only one of several surnames, or write "Last, First".
+func nameConfidence(name string, firstName string, middleName string, lastName string) float64 {
+	// "Last, First" is reordered to "First Last"
+	if parts := strings.SplitN(name, ",", 2); len(parts) == 2 {
+		name = parts[1] + " " + parts[0]
+	}
+
+	given := nameTokens(name)
+	firsts := nameTokens(firstName)
+	middles := nameTokens(middleName)
+	lasts := nameTokens(lastName)
+	givenNames := append(append([]string{}, firsts...), middles...)
+	if len(given) == 0 || len(firsts)+len(middles)+len(lasts) == 0 {
+		return 0
+	}
+
+	// a single word could be either name, but isn't enough to be confident
+	if len(given) == 1 {
+		single := math.Max(bestSimilarity(given[0], givenNames), bestSimilarity(given[0], lasts))
+		return round2(single / 2)
+	}
+
+	// first word is the given name; people may go by their middle name instead
+	first := bestSimilarity(given[0], givenNames)
+
+	// remaining words hold the surname, possibly with middle names before it
+	rest := given[1:]
+	last := wordSimilarity(strings.Join(rest, ""), strings.Join(lasts, ""))
+	for _, word := range rest {
+		// only writing one of several surnames is common, but slightly less certain
+		partial := bestSimilarity(word, lasts)
+		if len(lasts) > 1 {
+			partial *= 0.95
+		}
+		if partial > last {
+			last = partial
+		}
+	}
+	if len(lasts) > 1 {
+		// surnames may be written with middle names in between, so try the trailing words too
+		n := len(lasts)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if s := wordSimilarity(strings.Join(rest[len(rest)-n:], ""), strings.Join(lasts, "")); s > last {
+			last = s
+		}
+	}
+
+	return round2((first + last) / 2)
+}
+
+func round2(f float64) float64 {
+	return math.Floor(f*100+0.5) / 100
+}
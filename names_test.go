@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNameTokens(t *testing.T) {
+	type testCase struct {
+		expected []string
+		name     string
+	}
+	cases := []testCase{
+		testCase{expected: []string{"sidney", "kochman"}, name: "Sidney  Kochman"},
+		testCase{expected: []string{"mary", "jane", "smith"}, name: "Mary-Jane Smith"},
+		testCase{expected: []string{"jose", "garcia", "nunez"}, name: "José García Núñez"},
+		testCase{expected: []string{"sean", "obrien"}, name: "Seán O'Brien"},
+		testCase{expected: []string{"j", "r", "r", "tolkien"}, name: "J. R. R. Tolkien"},
+		testCase{expected: []string{}, name: "  "},
+	}
+
+	for _, c := range cases {
+		actual := nameTokens(c.name)
+		if len(actual) == 0 && len(c.expected) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%q: expected %v, got %v", c.name, c.expected, actual)
+		}
+	}
+}
+
+func TestNameConfidence(t *testing.T) {
+	type testCase struct {
+		above      bool
+		name       string
+		firstName  string
+		middleName string
+		lastName   string
+	}
+	cases := []testCase{
+		testCase{above: true, name: "Sidney Kochman", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+		testCase{above: true, name: "Sidney David Kochman", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+		testCase{above: true, name: "David Kochman", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+		testCase{above: true, name: "Kochman, Sidney", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+		testCase{above: true, name: "Sid Kochman", firstName: "Sidney", middleName: "", lastName: "Kochman"},
+		testCase{above: true, name: "Sidney Kochmann", firstName: "Sidney", middleName: "", lastName: "Kochman"},
+		testCase{above: true, name: "Jose Garcia Nunez", firstName: "José", middleName: "", lastName: "García Núñez"},
+		testCase{above: true, name: "Jose Garcia", firstName: "José", middleName: "", lastName: "García Núñez"},
+		testCase{above: true, name: "Jose Luis Garcia-Nunez", firstName: "José", middleName: "Luis", lastName: "García Núñez"},
+		testCase{above: true, name: "Mary Jane Smith", firstName: "Mary-Jane", middleName: "", lastName: "Smith"},
+		testCase{above: true, name: "Sean OBrien", firstName: "Seán", middleName: "", lastName: "O'Brien"},
+		testCase{above: false, name: "Joey Kochman", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+		testCase{above: false, name: "Sidney Lyon", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+		testCase{above: false, name: "Kochman", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+		testCase{above: false, name: "", firstName: "Sidney", middleName: "David", lastName: "Kochman"},
+	}
+
+	for _, c := range cases {
+		actual := nameConfidence(c.name, c.firstName, c.middleName, c.lastName)
+		if (actual >= nameConfidenceThreshold) != c.above {
+			t.Errorf("%q vs %s %s %s: expected above threshold %t, got confidence %.2f", c.name, c.firstName, c.middleName, c.lastName, c.above, actual)
+		}
+		if actual < 0 || actual > 1 {
+			t.Errorf("%q: confidence %.2f out of range", c.name, actual)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// nominatorLookup lists every nomination made by the student with the given RCS ID, across all
+// candidates and offices in an election. The election defaults to the active one.
+// Requires authorization, and only admins can use it.
+func nominatorLookup(w http.ResponseWriter, r *http.Request) {
+	// check if this user has permission to do this
+	admin := adminFromContext(r.Context())
+	if !admin {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// extract/validate RCS ID
+	rcs := strings.ToLower(r.FormValue("rcs"))
+	if rcs == "" {
+		writeMissing(w, r, "rcs", "missing rcs")
+		return
+	}
+	writeNominatorNominations(w, r, rcs)
+}
+
+// ownNominations lists every nomination the current user has made, across all candidates and
+// offices in an election, so students can check whom they've nominated. The election defaults to
+// the active one.
+// Requires authorization.
+func ownNominations(w http.ResponseWriter, r *http.Request) {
+	casUser := casUserFromContext(r.Context())
+	if casUser == "" {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+	writeNominatorNominations(w, r, casUser)
+}
+
+// writeNominatorNominations writes the nominations made by a nominator in the election given by
+// the request, oldest first.
+func writeNominatorNominations(w http.ResponseWriter, r *http.Request, rcs string) {
+	electionClause := activeElectionQuery
+	args := []interface{}{rcs}
+	if election := r.FormValue("election"); election != "" {
+		if _, err := strconv.Atoi(election); err != nil {
+			writeError(w, r, http.StatusUnprocessableEntity, "election", "election must be a number")
+			return
+		}
+		electionClause = "?"
+		args = append(args, election)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT nomination_id, nomination_partial_rin, nomination_rcs_id, valid, page, number, rcs_id, office_id, date FROM nominations WHERE nomination_rcs_id = ? AND election_id = "+electionClause+" ORDER BY date, rcs_id, office_id, page, number", args...)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	nominations := []pageNomination{}
+	for rows.Next() {
+		nomination := pageNomination{}
+		err = rows.Scan(&nomination.ID, &nomination.RIN, &nomination.RcsID, &nomination.Valid, &nomination.Page, &nomination.Number, &nomination.CandidateRCS, &nomination.OfficeID, &nomination.Submitted)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		nominations = append(nominations, nomination)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(nominations)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPageNominationJSON(t *testing.T) {
+	valid := true
+	nomination := pageNomination{
+		Nomination:   Nomination{ID: 7, RIN: "777", RcsID: "kochms", Valid: &valid, Page: 2, Number: 3},
+		CandidateRCS: "lyonj4",
+		OfficeID:     4,
+		Submitted:    time.Date(2018, time.March, 1, 12, 30, 0, 0, time.UTC),
+	}
+	expected := `{"id":7,"rin":"777","rcs":"kochms","valid":true,"page":2,"number":3,"candidate_rcs":"lyonj4","office_id":4,"submitted":"2018-03-01T12:30:00Z"}`
+
+	actual, err := json.Marshal(nomination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestNominatorNominations(t *testing.T) {
+	submitted := time.Date(2019, time.February, 1, 12, 0, 0, 0, time.UTC)
+	nominations := fakeQuery{
+		match:   "FROM nominations WHERE nomination_rcs_id = ?",
+		columns: []string{"nomination_id", "nomination_partial_rin", "nomination_rcs_id", "valid", "page", "number", "rcs_id", "office_id", "date"},
+		rows: [][]driver.Value{
+			[]driver.Value{int64(7), "123", "doej", int64(1), int64(1), int64(2), "lyonj4", int64(3), submitted},
+		},
+	}
+
+	type testCase struct {
+		expected int
+		handler  http.HandlerFunc
+		ctx      context.Context
+		target   string
+		queries  []fakeQuery
+		// args are what the nominations should have been looked up by
+		args []driver.Value
+	}
+	cases := []testCase{
+		// only admins can look up someone else's nominations
+		testCase{expected: http.StatusUnauthorized, handler: nominatorLookup, ctx: userContext("lyonj4", false), target: "/nominator?rcs=doej"},
+		testCase{expected: http.StatusUnauthorized, handler: nominatorLookup, ctx: unauthenticatedContext(context.Background()), target: "/nominator?rcs=doej"},
+		testCase{expected: http.StatusUnprocessableEntity, handler: nominatorLookup, ctx: userContext("admin1", true), target: "/nominator"},
+		testCase{expected: http.StatusUnprocessableEntity, handler: nominatorLookup, ctx: userContext("admin1", true), target: "/nominator?rcs=doej&election=spring"},
+		testCase{
+			expected: http.StatusOK, handler: nominatorLookup, ctx: userContext("admin1", true), target: "/nominator?rcs=DoeJ",
+			queries: []fakeQuery{nominations}, args: []driver.Value{"doej"},
+		},
+		testCase{
+			expected: http.StatusOK, handler: nominatorLookup, ctx: userContext("admin1", true), target: "/nominator?rcs=doej&election=2",
+			queries: []fakeQuery{nominations}, args: []driver.Value{"doej", "2"},
+		},
+		testCase{expected: http.StatusUnauthorized, handler: ownNominations, ctx: unauthenticatedContext(context.Background()), target: "/nominator/me"},
+		testCase{expected: http.StatusUnprocessableEntity, handler: ownNominations, ctx: userContext("doej", false), target: "/nominator/me?election=spring"},
+		// the RCS ID always comes from the session
+		testCase{
+			expected: http.StatusOK, handler: ownNominations, ctx: userContext("doej", false), target: "/nominator/me?rcs=smithj",
+			queries: []fakeQuery{nominations}, args: []driver.Value{"doej"},
+		},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		c.handler(w, httptest.NewRequest(http.MethodGet, c.target, nil).WithContext(c.ctx))
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expected, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		calls := db.called("FROM nominations WHERE nomination_rcs_id = ?")
+		if len(calls) != 1 || !reflect.DeepEqual(calls[0].args, c.args) {
+			t.Errorf("%s: expected nominations looked up by %v, got %+v", c.target, c.args, calls)
+		}
+		result := []pageNomination{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 1 || result[0].ID != 7 || result[0].CandidateRCS != "lyonj4" {
+			t.Errorf("%s: unexpected nominations %+v", c.target, result)
+		}
+	}
+}
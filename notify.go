@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// digestDelay is how long the notifier waits after a candidate's nomination is marked invalid or found
+// to have problems before emailing them, so a batch of them ends up in one digest.
+var digestDelay = 5 * time.Minute
+
+// notifierPollInterval is how often the notifier looks for digests that are due.
+var notifierPollInterval = 30 * time.Second
+
+// mailDomain returns the domain that RCS IDs are email addresses at, set by MAIL_DOMAIN.
+func mailDomain() string {
+	domain := os.Getenv("MAIL_DOMAIN")
+	if domain == "" {
+		domain = "rpi.edu"
+	}
+	return domain
+}
+
+// mailer sends plain text email.
+type mailer interface {
+	Send(to []string, subject string, body string) error
+}
+
+// smtpMailer sends email through an SMTP server.
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// getMailer returns a mailer for the SMTP server at SMTP_ADDR (host:port), sending from SMTP_FROM
+// (elections at the mail domain by default). If SMTP_USER is set, it authenticates with it and
+// SMTP_PASSWORD. It returns nil if SMTP_ADDR isn't set, in which case no email is sent.
+func getMailer() mailer {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return nil
+	}
+	m := &smtpMailer{addr: addr, from: os.Getenv("SMTP_FROM")}
+	if m.from == "" {
+		m.from = "elections@" + mailDomain()
+	}
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		m.auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return m
+}
+
+func (m *smtpMailer) Send(to []string, subject string, body string) error {
+	msg := "From: " + m.from + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.Replace(body, "\n", "\r\n", -1)
+	return smtp.SendMail(m.addr, m.auth, m.from, to, []byte(msg))
+}
+
+// digestLine is a nomination line with problems, to be listed in a digest.
+type digestLine struct {
+	OfficeName   string
+	Page         int
+	Number       int
+	NominatorRCS string
+	Problems     Problems
+}
+
+// digest is what a candidate is told about their nominations after validation.
+type digest struct {
+	Recipients []string
+	Valid      int
+	Pending    int
+	Invalid    []digestLine
+}
+
+// digestRecipients returns the email addresses of a candidate and her assistants at a mail domain.
+func digestRecipients(rcs string, assistants []string, domain string) []string {
+	recipients := []string{rcs + "@" + domain}
+	for _, assistant := range assistants {
+		address := strings.ToLower(assistant) + "@" + domain
+		if !contains(recipients, address) {
+			recipients = append(recipients, address)
+		}
+	}
+	return recipients
+}
+
+// body returns the text of the digest email.
+func (d digest) body() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your nominations have been checked. You have %d valid, %d invalid and %d pending nominations.\n", d.Valid, len(d.Invalid), d.Pending)
+	if len(d.Invalid) > 0 {
+		b.WriteString("\nThese nominations are invalid:\n\n")
+		for _, line := range d.Invalid {
+			fmt.Fprintf(&b, "%s, page %d, line %d (%s):\n", line.OfficeName, line.Page, line.Number, line.NominatorRCS)
+			for _, problem := range line.Problems {
+				fmt.Fprintf(&b, "  - %s\n", problem)
+			}
+		}
+		b.WriteString("\nIf you think a nomination was marked invalid by mistake, you can appeal it on the elections site.\n")
+	}
+	return b.String()
+}
+
+// loadDigest reads the current state of a candidate's nominations in the active election. Lines an
+// admin marked invalid count as invalid, as do lines the validator found problems with that no admin
+// has decided on yet.
+func loadDigest(rcs string) (digest, error) {
+	d := digest{Invalid: []digestLine{}}
+
+	assistants, err := getCandidateAssistants(rcs)
+	if err != nil {
+		return d, err
+	}
+	d.Recipients = digestRecipients(rcs, assistants, mailDomain())
+
+	db, err := getDB()
+	if err != nil {
+		return d, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT o.name, n.page, n.number, n.nomination_rcs_id, n.valid, COALESCE(r.problems, '[]') FROM nominations n JOIN offices o ON o.office_id = n.office_id LEFT JOIN nomination_reviews r ON r.nomination_id = n.nomination_id WHERE n.rcs_id = ? AND n.election_id = "+activeElectionQuery+" ORDER BY o.name, n.page, n.number", rcs)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		line := digestLine{}
+		var valid *bool
+		var problems string
+		err = rows.Scan(&line.OfficeName, &line.Page, &line.Number, &line.NominatorRCS, &valid, &problems)
+		if err != nil {
+			return d, err
+		}
+		err = json.Unmarshal([]byte(problems), &line.Problems)
+		if err != nil {
+			return d, err
+		}
+		switch {
+		case valid != nil && *valid:
+			d.Valid++
+		case valid != nil || len(line.Problems) > 0:
+			d.Invalid = append(d.Invalid, line)
+		default:
+			d.Pending++
+		}
+	}
+	return d, rows.Err()
+}
+
+// queueDigest schedules a digest for a candidate after digestDelay, unless one is already scheduled.
+// It's called when a nomination is validated with problems, and in the transaction that marks a
+// nomination invalid, so the digest isn't lost if the program stops before it's sent.
+func queueDigest(ex execer, rcs string) error {
+	_, err := ex.Exec("INSERT INTO pending_digests (candidate_rcs_id, due_at) VALUES (?, NOW() + INTERVAL ? SECOND) ON DUPLICATE KEY UPDATE due_at = due_at", strings.ToLower(rcs), int(digestDelay.Seconds()))
+	return err
+}
+
+// notifier emails candidates a digest of their invalid nominations. Nominations marked invalid or
+// found to have problems within digestDelay of each other are reported together.
+type notifier struct {
+	mailer mailer
+	load   func(rcs string) (digest, error)
+}
+
+func newNotifier(m mailer) *notifier {
+	return &notifier{mailer: m, load: loadDigest}
+}
+
+// startNotifier emails digests as they come due until the program exits. It does nothing if no
+// mailer is configured.
+func startNotifier() {
+	m := getMailer()
+	if m == nil {
+		log.Print("SMTP_ADDR is not set; email notifications are off")
+		return
+	}
+	n := newNotifier(m)
+	go func() {
+		for {
+			err := n.sendDue()
+			if err != nil {
+				log.Printf("unable to send digests: %s", err.Error())
+			}
+			time.Sleep(notifierPollInterval)
+		}
+	}()
+}
+
+// sendDue sends every digest that is due. Each is taken off the queue before it's sent, so it's only
+// sent once, and put back if sending fails.
+func (n *notifier) sendDue() error {
+	db, err := getDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT candidate_rcs_id FROM pending_digests WHERE due_at <= NOW()")
+	if err != nil {
+		return err
+	}
+	due := []string{}
+	for rows.Next() {
+		var rcs string
+		err = rows.Scan(&rcs)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, rcs)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rcs := range due {
+		res, err := db.Exec("DELETE FROM pending_digests WHERE candidate_rcs_id = ? AND due_at <= NOW()", rcs)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		err = n.send(rcs)
+		if err != nil {
+			log.Printf("unable to send digest to %s: %s", rcs, err.Error())
+			err = queueDigest(db, rcs)
+			if err != nil {
+				log.Printf("unable to requeue digest for %s: %s", rcs, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// send emails a candidate their digest, if they still have invalid nominations.
+func (n *notifier) send(rcs string) error {
+	d, err := n.load(rcs)
+	if err != nil {
+		return err
+	}
+	if len(d.Invalid) == 0 {
+		return nil
+	}
+	err = n.mailer.Send(d.Recipients, "Your nominations have been checked", d.body())
+	if err != nil {
+		return err
+	}
+	log.Printf("sent digest to %s (%d invalid nominations)", rcs, len(d.Invalid))
+	return nil
+}
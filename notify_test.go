@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeMailer records email instead of sending it.
+type fakeMailer struct {
+	mutex sync.Mutex
+	sent  []fakeMail
+}
+
+type fakeMail struct {
+	to      []string
+	subject string
+	body    string
+}
+
+func (m *fakeMailer) Send(to []string, subject string, body string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sent = append(m.sent, fakeMail{to: to, subject: subject, body: body})
+	return nil
+}
+
+func (m *fakeMailer) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.sent)
+}
+
+func TestDigestRecipients(t *testing.T) {
+	expected := []string{"lyonj4@example.edu", "kochms@example.edu"}
+	actual := digestRecipients("lyonj4", []string{"KOCHMS", "lyonj4"}, "example.edu")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestDigestBody(t *testing.T) {
+	d := digest{Valid: 40, Pending: 2, Invalid: []digestLine{
+		digestLine{OfficeName: "President", Page: 1, Number: 3, NominatorRCS: "smithj", Problems: Problems{problem(codeNotStudent, "Not a student."), problem(codeRINMismatch, "Mismatched RIN digits.")}},
+	}}
+	body := d.body()
+	for _, expected := range []string{
+		"You have 40 valid, 1 invalid and 2 pending nominations.",
+		"President, page 1, line 3 (smithj):\n  - Not a student.\n  - Mismatched RIN digits.\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected body to contain %q, got %q", expected, body)
+		}
+	}
+
+	body = digest{Valid: 3, Invalid: []digestLine{}}.body()
+	if strings.Contains(body, "invalid:") {
+		t.Errorf("expected no list of invalid nominations, got %q", body)
+	}
+}
+
+func TestLoadDigest(t *testing.T) {
+	_, done := useFakeDB(t,
+		fakeQuery{match: "FROM assistants", columns: []string{"rcs_id"}, rows: [][]driver.Value{[]driver.Value{"KOCHMS"}}},
+		fakeQuery{match: "FROM nominations n JOIN offices o", columns: []string{"name", "page", "number", "nomination_rcs_id", "valid", "problems"}, rows: [][]driver.Value{
+			[]driver.Value{"President", int64(1), int64(1), "doej", int64(1), `[]`},
+			[]driver.Value{"President", int64(1), int64(2), "smithj", int64(0), `["Not a student."]`},
+			// problems the validator found make a line invalid, unless an admin decided otherwise
+			[]driver.Value{"President", int64(1), int64(3), "roej", nil, `["Mismatched RIN digits."]`},
+			[]driver.Value{"President", int64(1), int64(4), "poej", nil, `[]`},
+			[]driver.Value{"President", int64(1), int64(5), "hoej", int64(1), `["Not a student."]`},
+		}},
+	)
+	defer done()
+	defer os.Setenv("MAIL_DOMAIN", os.Getenv("MAIL_DOMAIN"))
+	os.Setenv("MAIL_DOMAIN", "example.edu")
+
+	d, err := loadDigest("lyonj4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Valid != 2 || d.Pending != 1 || len(d.Invalid) != 2 || d.Invalid[0].NominatorRCS != "smithj" || d.Invalid[1].NominatorRCS != "roej" || d.Invalid[1].Problems[0].Message != "Mismatched RIN digits." {
+		t.Errorf("unexpected digest %+v", d)
+	}
+	if !reflect.DeepEqual(d.Recipients, []string{"lyonj4@example.edu", "kochms@example.edu"}) {
+		t.Errorf("unexpected recipients %v", d.Recipients)
+	}
+}
+
+func TestNotifierSendDue(t *testing.T) {
+	digestColumns := []string{"candidate_rcs_id"}
+	take := "DELETE FROM pending_digests"
+	db, done := useFakeDB(t,
+		fakeQuery{match: "SELECT candidate_rcs_id FROM pending_digests", columns: digestColumns, rows: [][]driver.Value{
+			[]driver.Value{"lyonj4"},
+			// no longer has any invalid nominations
+			[]driver.Value{"kochms"},
+			// already taken by another notifier
+			[]driver.Value{"smithj"},
+		}},
+		fakeQuery{match: take, affected: 1},
+		fakeQuery{match: take, affected: 1},
+		fakeQuery{match: take, affected: 0},
+	)
+	defer done()
+
+	m := &fakeMailer{}
+	n := newNotifier(m)
+	loaded := []string{}
+	n.load = func(rcs string) (digest, error) {
+		loaded = append(loaded, rcs)
+		d := digest{Recipients: []string{rcs + "@rpi.edu"}, Invalid: []digestLine{}}
+		if rcs == "lyonj4" {
+			d.Invalid = append(d.Invalid, digestLine{OfficeName: "President", Page: 1, Number: 2, NominatorRCS: "smithj"})
+		}
+		return d, nil
+	}
+
+	err := n.sendDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, []string{"lyonj4", "kochms"}) {
+		t.Errorf("expected digests loaded for lyonj4 and kochms, got %v", loaded)
+	}
+	if count := m.count(); count != 1 {
+		t.Fatalf("expected 1 digest, got %d", count)
+	}
+	if !reflect.DeepEqual(m.sent[0].to, []string{"lyonj4@rpi.edu"}) {
+		t.Errorf("expected digest to lyonj4@rpi.edu, got %v", m.sent[0].to)
+	}
+	if calls := db.called("INSERT INTO pending_digests"); len(calls) != 0 {
+		t.Errorf("expected nothing to be requeued, got %+v", calls)
+	}
+}
+
+func TestNotifierRequeuesFailedDigests(t *testing.T) {
+	db, done := useFakeDB(t,
+		fakeQuery{match: "SELECT candidate_rcs_id FROM pending_digests", columns: []string{"candidate_rcs_id"}, rows: [][]driver.Value{[]driver.Value{"lyonj4"}}},
+		fakeQuery{match: "DELETE FROM pending_digests", affected: 1},
+		fakeQuery{match: "INSERT INTO pending_digests", affected: 1},
+	)
+	defer done()
+
+	n := newNotifier(&fakeMailer{})
+	n.load = func(rcs string) (digest, error) {
+		return digest{}, errors.New("database is down")
+	}
+	err := n.sendDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := db.called("INSERT INTO pending_digests")
+	if len(calls) != 1 || calls[0].args[0] != "lyonj4" {
+		t.Errorf("expected the digest to be requeued, got %+v", calls)
+	}
+}
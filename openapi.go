@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes every endpoint as an OpenAPI 3.0 document, kept in openapi.json. Keep it in
+// step with newRouter and the response types; the tests check that it covers every route and that
+// responses match it.
+//
+//go:embed openapi.json
+var openAPISpec string
+
+// openAPIDocument serves the OpenAPI document describing the API.
+func openAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
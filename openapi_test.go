@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// loadSpec parses the OpenAPI document as generic JSON.
+func loadSpec(t *testing.T) map[string]interface{} {
+	spec := map[string]interface{}{}
+	err := json.Unmarshal([]byte(openAPISpec), &spec)
+	if err != nil {
+		t.Fatalf("unable to parse OpenAPI document: %s", err.Error())
+	}
+	return spec
+}
+
+// specLookup follows a local reference such as "#/components/schemas/Nomination".
+func specLookup(spec map[string]interface{}, ref string) map[string]interface{} {
+	node := interface{}(spec)
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[part]
+	}
+	m, _ := node.(map[string]interface{})
+	return m
+}
+
+// checkSchema returns the ways value doesn't match schema. It understands the parts of JSON Schema
+// that the document uses: $ref, allOf, type, nullable, enum, properties, additionalProperties,
+// required and items. Objects whose schema lists properties can't have any others, so the document
+// has to describe everything a response includes.
+func checkSchema(spec map[string]interface{}, schema map[string]interface{}, value interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved := specLookup(spec, ref)
+		if resolved == nil {
+			return []string{path + ": unknown reference " + ref}
+		}
+		return checkSchema(spec, resolved, value, path)
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		return checkSchema(spec, mergeAllOf(spec, allOf), value, path)
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schema["type"] == nil {
+			return nil
+		}
+		return []string{path + ": unexpected null"}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if allowed == value {
+				found = true
+			}
+		}
+		if !found {
+			return []string{fmt.Sprintf("%s: %v is not one of %v", path, value, enum)}
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{path + ": expected an object"}
+		}
+		problems := []string{}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					problems = append(problems, path+": missing "+name.(string))
+				}
+			}
+		}
+		properties, declared := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, v := range object {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				problems = append(problems, checkSchema(spec, property, v, path+"."+name)...)
+			} else if additional != nil {
+				problems = append(problems, checkSchema(spec, additional, v, path+"."+name)...)
+			} else if declared {
+				problems = append(problems, path+": undocumented property "+name)
+			}
+		}
+		return problems
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{path + ": expected an array"}
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		problems := []string{}
+		for i, v := range array {
+			problems = append(problems, checkSchema(spec, items, v, path+"["+strconv.Itoa(i)+"]")...)
+		}
+		return problems
+	case "string":
+		if _, ok := value.(string); !ok {
+			return []string{path + ": expected a string"}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return []string{path + ": expected an integer"}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{path + ": expected a number"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{path + ": expected a boolean"}
+		}
+	}
+	return nil
+}
+
+// mergeAllOf combines the object schemas in an allOf into one, so that properties declared by any of
+// them are allowed.
+func mergeAllOf(spec map[string]interface{}, allOf []interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []interface{}{}
+	for _, sub := range allOf {
+		schema, _ := sub.(map[string]interface{})
+		if ref, ok := schema["$ref"].(string); ok {
+			schema = specLookup(spec, ref)
+		}
+		if nested, ok := schema["allOf"].([]interface{}); ok {
+			schema = mergeAllOf(spec, nested)
+		}
+		subProperties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range subProperties {
+			properties[name] = property
+		}
+		subRequired, _ := schema["required"].([]interface{})
+		required = append(required, subRequired...)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadSpec(t)
+	paths := spec["paths"].(map[string]interface{})
+
+	routed := map[string]bool{}
+	err := chi.Walk(newRouter(), func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		operation := strings.ToLower(method) + " " + route
+		routed[operation] = true
+		item, _ := paths[route].(map[string]interface{})
+		if _, ok := item[strings.ToLower(method)]; !ok {
+			t.Errorf("%s isn't documented", operation)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for route, item := range paths {
+		for method, operation := range item.(map[string]interface{}) {
+			if !routed[method+" "+route] {
+				t.Errorf("%s %s is documented but not routed", method, route)
+			}
+			responses := operation.(map[string]interface{})["responses"].(map[string]interface{})
+			if _, ok := responses["500"]; !ok {
+				t.Errorf("%s %s doesn't document internal errors", method, route)
+			}
+		}
+	}
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	spec := loadSpec(t)
+
+	anonymous := unauthenticatedContext(context.Background())
+	admin := context.WithValue(anonymous, casUserKey, "admin1")
+	admin = context.WithValue(admin, adminKey, true)
+	admin = context.WithValue(admin, authenticatedKey, true)
+	user := context.WithValue(anonymous, casUserKey, "lyonj4")
+	user = context.WithValue(user, authenticatedKey, true)
+	token := context.WithValue(admin, scopesKey, []scope{scopeReadCounts})
+	submitted := time.Date(2019, time.February, 1, 12, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		method string
+		target string
+		body   string
+		// ctx is nil if the request goes through the router unauthenticated
+		ctx     context.Context
+		handler http.Handler
+		queries []fakeQuery
+		status  int
+	}
+	cases := []testCase{
+		testCase{method: http.MethodGet, target: "/openapi.json", status: http.StatusOK},
+		testCase{method: http.MethodPut, target: "/?nomination=1", body: "{}", status: http.StatusUnauthorized},
+		testCase{method: http.MethodGet, target: "/nominations", status: http.StatusUnauthorized},
+		testCase{method: http.MethodGet, target: "/nominator?rcs=lyonj4", status: http.StatusUnauthorized},
+		testCase{method: http.MethodGet, target: "/nominator/me", status: http.StatusUnauthorized},
+		testCase{method: http.MethodGet, target: "/review", status: http.StatusUnauthorized},
+		testCase{method: http.MethodPost, target: "/logout", status: http.StatusUnauthorized},
+		testCase{method: http.MethodGet, target: "/tokens", status: http.StatusUnauthorized},
+		testCase{method: http.MethodGet, target: "/webhooks", status: http.StatusUnauthorized},
+		testCase{method: http.MethodGet, target: "/nominator", ctx: admin, handler: http.HandlerFunc(nominatorLookup), status: http.StatusUnprocessableEntity},
+		testCase{method: http.MethodGet, target: "/nominator/me?election=latest", ctx: user, handler: http.HandlerFunc(ownNominations), status: http.StatusUnprocessableEntity},
+		testCase{method: http.MethodGet, target: "/nominations?sort=name", ctx: admin, handler: http.HandlerFunc(browseNominations), status: http.StatusUnprocessableEntity},
+		testCase{method: http.MethodGet, target: "/validate", ctx: admin, handler: http.HandlerFunc(validateNomination), status: http.StatusUnprocessableEntity},
+		testCase{method: http.MethodPost, target: "/review/claim", ctx: admin, handler: http.HandlerFunc(claimNomination), status: http.StatusUnprocessableEntity},
+		testCase{method: http.MethodGet, target: "/export?election=latest", ctx: admin, handler: http.HandlerFunc(exportNominations), status: http.StatusUnprocessableEntity},
+		testCase{method: http.MethodPost, target: "/tokens", body: "{", ctx: admin, handler: http.HandlerFunc(createToken), status: http.StatusBadRequest},
+		testCase{method: http.MethodGet, target: "/webhooks/deliveries", ctx: admin, handler: http.HandlerFunc(listWebhookDeliveries), status: http.StatusUnprocessableEntity},
+		testCase{method: http.MethodPost, target: "/?rcs=lyonj4&office=3", body: "[]", ctx: token, handler: requireScope(scopeWrite)(http.HandlerFunc(addNominations)), status: http.StatusForbidden},
+		// successful responses, with rows from the fake database
+		testCase{
+			method: http.MethodGet, target: "/?rcs=lyonj4", ctx: user, handler: http.HandlerFunc(listNominations), status: http.StatusOK,
+			queries: []fakeQuery{
+				fakeQuery{match: "FROM nominations WHERE rcs_id = ?", columns: []string{"nomination_id", "nomination_partial_rin", "nomination_rcs_id", "valid", "page", "office_id", "date", "number"}, rows: [][]driver.Value{
+					[]driver.Value{int64(1), "123", "doej", int64(1), int64(1), int64(3), submitted, int64(1)},
+					[]driver.Value{int64(2), "234", "roej", nil, int64(1), int64(3), submitted, int64(2)},
+				}},
+			},
+		},
+		testCase{
+			method: http.MethodGet, target: "/counts", ctx: token, handler: http.HandlerFunc(nominationCounts), status: http.StatusOK,
+			queries: []fakeQuery{
+				fakeQuery{match: "COUNT(*) as nominations", columns: []string{"rcs_id", "office_id", "nominations"}, rows: [][]driver.Value{
+					[]driver.Value{"lyonj4", int64(3), int64(25)},
+				}},
+			},
+		},
+		testCase{
+			method: http.MethodGet, target: "/nominations?limit=1", ctx: admin, handler: http.HandlerFunc(browseNominations), status: http.StatusOK,
+			queries: []fakeQuery{
+				fakeQuery{match: "SELECT rcs_id, office_id, page, submitted FROM", columns: []string{"rcs_id", "office_id", "page", "submitted"}, rows: [][]driver.Value{
+					[]driver.Value{"lyonj4", int64(3), int64(1), submitted},
+					[]driver.Value{"smithj", int64(3), int64(1), submitted},
+				}},
+				fakeQuery{match: "SELECT nomination_id, nomination_partial_rin", columns: []string{"nomination_id", "nomination_partial_rin", "nomination_rcs_id", "valid", "page", "number", "rcs_id", "office_id", "date"}, rows: [][]driver.Value{
+					[]driver.Value{int64(1), "123", "doej", int64(1), int64(1), int64(1), "lyonj4", int64(3), submitted},
+					[]driver.Value{int64(2), "234", "roej", nil, int64(1), int64(2), "lyonj4", int64(3), submitted},
+				}},
+			},
+		},
+		testCase{
+			method: http.MethodGet, target: "/tokens", ctx: admin, handler: http.HandlerFunc(listTokens), status: http.StatusOK,
+			queries: []fakeQuery{
+				fakeQuery{match: "FROM api_tokens ORDER BY", columns: []string{"token_id", "name", "scopes", "created_by", "created_at", "revoked_at"}, rows: [][]driver.Value{
+					[]driver.Value{int64(1), "results board", "counts:read", "admin1", submitted, nil},
+					[]driver.Value{int64(2), "old script", "nominations:read,validate", "admin1", submitted, submitted},
+				}},
+			},
+		},
+		testCase{
+			method: http.MethodGet, target: "/webhooks", ctx: admin, handler: http.HandlerFunc(listWebhooks), status: http.StatusOK,
+			queries: []fakeQuery{
+				fakeQuery{match: "FROM webhooks ORDER BY", columns: []string{"webhook_id", "url", "events", "active", "created_by", "created_at"}, rows: [][]driver.Value{
+					[]driver.Value{int64(1), "https://example.com/hook", "page.submitted,candidate.qualified", int64(1), "admin1", submitted},
+				}},
+			},
+		},
+		testCase{
+			method: http.MethodGet, target: "/webhooks/deliveries?webhook=1", ctx: admin, handler: http.HandlerFunc(listWebhookDeliveries), status: http.StatusOK,
+			queries: []fakeQuery{
+				fakeQuery{match: "FROM webhook_deliveries WHERE webhook_id = ?", columns: []string{"delivery_id", "webhook_id", "event", "payload", "attempts", "status_code", "error", "delivered", "created_at", "last_attempt_at", "next_attempt_at"}, rows: [][]driver.Value{
+					[]driver.Value{int64(2), int64(1), "page.submitted", []byte(`{"event":"page.submitted"}`), int64(0), nil, nil, int64(0), submitted, nil, submitted},
+					[]driver.Value{int64(1), int64(1), "page.submitted", []byte(`{"event":"page.submitted"}`), int64(1), int64(200), nil, int64(1), submitted, submitted, nil},
+				}},
+			},
+		},
+	}
+
+	router := newRouter()
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		_, done := useFakeDB(t, c.queries...)
+		if c.ctx == nil {
+			router.ServeHTTP(w, r)
+		} else {
+			c.handler.ServeHTTP(w, r.WithContext(c.ctx))
+		}
+		done()
+
+		name := c.method + " " + c.target
+		if w.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d: %s", name, c.status, w.Code, w.Body.String())
+			continue
+		}
+
+		route := strings.SplitN(c.target, "?", 2)[0]
+		item, _ := spec["paths"].(map[string]interface{})[route].(map[string]interface{})
+		operation, ok := item[strings.ToLower(c.method)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: operation isn't documented", name)
+			continue
+		}
+		response, ok := operation["responses"].(map[string]interface{})[strconv.Itoa(w.Code)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: status %d isn't documented", name, w.Code)
+			continue
+		}
+		if ref, ok := response["$ref"].(string); ok {
+			response = specLookup(spec, ref)
+		}
+
+		content, _ := response["content"].(map[string]interface{})
+		media, ok := content["application/json"].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: status %d isn't documented as JSON", name, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: expected application/json, got %q", name, ct)
+		}
+		var body interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if err != nil {
+			t.Errorf("%s: unable to parse body: %s", name, err.Error())
+			continue
+		}
+		problems := checkSchema(spec, media["schema"].(map[string]interface{}), body, "body")
+		sort.Strings(problems)
+		for _, problem := range problems {
+			t.Errorf("%s: %s", name, problem)
+		}
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	spec := loadSpec(t)
+	nomination := map[string]interface{}{"$ref": "#/components/schemas/Nomination"}
+	pageNomination := map[string]interface{}{"$ref": "#/components/schemas/PageNomination"}
+
+	type testCase struct {
+		schema   map[string]interface{}
+		value    string
+		problems int
+	}
+	cases := []testCase{
+		testCase{schema: nomination, value: `{"id": 1, "rin": "123", "rcs": "kochms", "valid": null, "page": 1, "number": 2}`, problems: 0},
+		testCase{schema: nomination, value: `{"id": "1", "rin": "123", "rcs": "kochms", "valid": true, "page": 1, "number": 2}`, problems: 1},
+		testCase{schema: nomination, value: `{"id": 1.5, "valid": "yes"}`, problems: 2},
+		testCase{schema: nomination, value: `[]`, problems: 1},
+		testCase{schema: nomination, value: `{"id": 1, "rin": "123", "rcs": "kochms", "valid": null, "page": 1, "number": 2, "secret": "x"}`, problems: 1},
+		// properties from every part of an allOf are declared
+		testCase{schema: pageNomination, value: `{"id": 1, "rin": "123", "rcs": "kochms", "valid": null, "page": 1, "number": 2, "candidate_rcs": "lyonj4", "office_id": 3, "submitted": "2019-03-01T12:00:00Z"}`, problems: 0},
+		testCase{schema: pageNomination, value: `{"id": 1, "rin": "123", "rcs": "kochms", "valid": null, "page": 1, "number": 2, "candidate_rcs": "lyonj4", "office_id": 3, "submitted": "2019-03-01T12:00:00Z", "flagged": true}`, problems: 1},
+		testCase{schema: pageNomination, value: `{"id": 1, "rin": "123", "rcs": "kochms", "valid": null, "page": 1, "number": 2}`, problems: 3},
+	}
+
+	for _, c := range cases {
+		var value interface{}
+		err := json.Unmarshal([]byte(c.value), &value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems := checkSchema(spec, c.schema, value, "value")
+		if len(problems) != c.problems {
+			t.Errorf("expected %d problems with %s, got %v", c.problems, c.value, problems)
+		}
+	}
+}
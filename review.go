@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// claimTimeout is how long a claim on a nomination lasts before someone else can take it over.
+const claimTimeout = 30 * time.Minute
+
+// reviewItem is a nomination in the manual review queue, along with its latest validation result.
+type reviewItem struct {
+	Nomination
+	CandidateRCS string     `json:"candidate_rcs"`
+	OfficeID     int        `json:"office_id"`
+	Submitted    time.Time  `json:"submitted"`
+	Flagged      bool       `json:"flagged"`
+	Problems     Problems   `json:"problems"`
+	Warnings     Problems   `json:"warnings"`
+	ProblemCodes []string   `json:"problem_codes"`
+	ClaimedBy    *string    `json:"claimed_by"`
+	ClaimedAt    *time.Time `json:"claimed_at"`
+}
+
+// saveValidation stores the result of validating a nomination, so that it shows up in the review
+// queue with its problems. Nominations with warnings are flagged for manual review, and if the
+// nomination has problems, the candidate is sent a digest of them.
+func saveValidation(ex execer, candidateRCS string, nominationID int, vn ValidNomination) error {
+	problems, err := json.Marshal(vn.Problems)
+	if err != nil {
+		return err
+	}
+	warnings, err := json.Marshal(vn.Warnings)
+	if err != nil {
+		return err
+	}
+	codes := append(append(Problems{}, vn.Problems...), vn.Warnings...).codes()
+
+	_, err = ex.Exec("INSERT INTO nomination_reviews (nomination_id, flagged, problems, warnings, problem_codes, checked_at) VALUES (?, ?, ?, ?, ?, NOW()) ON DUPLICATE KEY UPDATE flagged = VALUES(flagged), problems = VALUES(problems), warnings = VALUES(warnings), problem_codes = VALUES(problem_codes), checked_at = VALUES(checked_at)", nominationID, len(vn.Warnings) > 0, problems, warnings, strings.Join(codes, ","))
+	if err != nil || len(vn.Problems) == 0 {
+		return err
+	}
+	return queueDigest(ex, candidateRCS)
+}
+
+// resolveReview takes a nomination out of the review queue once an admin has decided whether it's
+// valid, by clearing its flag and any claim on it.
+func resolveReview(ex execer, nominationID int) error {
+	_, err := ex.Exec("UPDATE nomination_reviews SET flagged = false, claimed_by = NULL, claimed_at = NULL WHERE nomination_id = ?", nominationID)
+	return err
+}
+
+// reviewQueue lists nominations in the active election that are pending or flagged for manual review,
+// oldest first, across all candidates. It can be filtered by office and by problem code, and shows who
+// has claimed each nomination.
+// Requires authorization, and only admins can use it.
+func reviewQueue(w http.ResponseWriter, r *http.Request) {
+	// check if this user has permission to do this
+	admin := adminFromContext(r.Context())
+	if !admin {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	query := "SELECT n.nomination_id, n.nomination_partial_rin, n.nomination_rcs_id, n.valid, n.page, n.number, n.rcs_id, n.office_id, n.date, COALESCE(r.flagged, false), COALESCE(r.problems, '[]'), COALESCE(r.warnings, '[]'), COALESCE(r.problem_codes, ''), r.claimed_by, r.claimed_at FROM nominations n LEFT JOIN nomination_reviews r ON r.nomination_id = n.nomination_id WHERE n.election_id = " + activeElectionQuery + " AND (n.valid IS NULL OR r.flagged = true)"
+	args := []interface{}{}
+	if office := r.FormValue("office"); office != "" {
+		query += " AND n.office_id = ?"
+		args = append(args, office)
+	}
+	if problem := r.FormValue("problem"); problem != "" {
+		query += " AND FIND_IN_SET(?, r.problem_codes) > 0"
+		args = append(args, problem)
+	}
+	query += " ORDER BY n.date, n.nomination_id"
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := []reviewItem{}
+	for rows.Next() {
+		item := reviewItem{}
+		var problems, warnings, codes string
+		err = rows.Scan(&item.ID, &item.RIN, &item.RcsID, &item.Valid, &item.Page, &item.Number, &item.CandidateRCS, &item.OfficeID, &item.Submitted, &item.Flagged, &problems, &warnings, &codes, &item.ClaimedBy, &item.ClaimedAt)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		err = json.Unmarshal([]byte(problems), &item.Problems)
+		if err == nil {
+			err = json.Unmarshal([]byte(warnings), &item.Warnings)
+		}
+		if err != nil {
+			log.Printf("unable to decode JSON: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		item.ProblemCodes = []string{}
+		if codes != "" {
+			item.ProblemCodes = strings.Split(codes, ",")
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(items)
+}
+
+// claimNomination marks a nomination as being reviewed by the current user, so other reviewers can
+// skip it. A nomination claimed by someone else can't be claimed until they release it or the claim
+// times out.
+// Requires authorization, and only admins can use it.
+func claimNomination(w http.ResponseWriter, r *http.Request) {
+	updateClaim(w, r, true)
+}
+
+// releaseNomination gives up the current user's claim on a nomination.
+// Requires authorization, and only admins can use it.
+func releaseNomination(w http.ResponseWriter, r *http.Request) {
+	updateClaim(w, r, false)
+}
+
+func updateClaim(w http.ResponseWriter, r *http.Request, claim bool) {
+	// check if this user has permission to do this
+	admin := adminFromContext(r.Context())
+	if !admin {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+	casUser := casUserFromContext(r.Context())
+
+	// extract/validate nomination ID
+	nomID := r.FormValue("nomination")
+	if nomID == "" {
+		writeMissing(w, r, "nomination", "missing nomination ID")
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("unable to begin transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// find the nomination and lock any existing claim on it
+	row := tx.QueryRow("SELECT r.claimed_by, COALESCE(r.claimed_at > NOW() - INTERVAL ? SECOND, false) FROM nominations n LEFT JOIN nomination_reviews r ON r.nomination_id = n.nomination_id WHERE n.nomination_id = ? AND n.election_id = "+activeElectionQuery+" FOR UPDATE", int(claimTimeout.Seconds()), nomID)
+	var claimedBy sql.NullString
+	var claimCurrent bool
+	err = row.Scan(&claimedBy, &claimCurrent)
+	if err == sql.ErrNoRows {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	claimedByOther := claimedBy.Valid && claimedBy.String != casUser && claimCurrent
+	if claimedByOther {
+		writeError(w, r, http.StatusConflict, "", "nomination claimed by "+claimedBy.String)
+		return
+	}
+
+	if claim {
+		_, err = tx.Exec("INSERT INTO nomination_reviews (nomination_id, problems, warnings, claimed_by, claimed_at) VALUES (?, '[]', '[]', ?, NOW()) ON DUPLICATE KEY UPDATE claimed_by = VALUES(claimed_by), claimed_at = VALUES(claimed_at)", nomID, casUser)
+	} else {
+		_, err = tx.Exec("UPDATE nomination_reviews SET claimed_by = NULL, claimed_at = NULL WHERE nomination_id = ?", nomID)
+	}
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("unable to commit transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
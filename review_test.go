@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateClaim(t *testing.T) {
+	claimColumns := []string{"claimed_by", "current"}
+
+	type testCase struct {
+		expected int
+		handler  http.HandlerFunc
+		ctx      context.Context
+		target   string
+		queries  []fakeQuery
+		// write is the statement expected to change the claim, if any
+		write string
+	}
+	cases := []testCase{
+		testCase{expected: http.StatusUnauthorized, handler: claimNomination, ctx: userContext("lyonj4", false), target: "/review/claim?nomination=7"},
+		testCase{expected: http.StatusUnprocessableEntity, handler: claimNomination, ctx: userContext("admin1", true), target: "/review/claim"},
+		testCase{
+			expected: http.StatusNotFound, handler: claimNomination, ctx: userContext("admin1", true), target: "/review/claim?nomination=7",
+			queries: []fakeQuery{fakeQuery{match: "SELECT r.claimed_by", columns: claimColumns, rows: [][]driver.Value{}}},
+		},
+		testCase{
+			expected: http.StatusNoContent, handler: claimNomination, ctx: userContext("admin1", true), target: "/review/claim?nomination=7",
+			queries: []fakeQuery{
+				fakeQuery{match: "SELECT r.claimed_by", columns: claimColumns, rows: [][]driver.Value{[]driver.Value{nil, int64(0)}}},
+				fakeQuery{match: "INSERT INTO nomination_reviews", affected: 1},
+			},
+			write: "INSERT INTO nomination_reviews",
+		},
+		testCase{
+			expected: http.StatusConflict, handler: claimNomination, ctx: userContext("admin1", true), target: "/review/claim?nomination=7",
+			queries: []fakeQuery{fakeQuery{match: "SELECT r.claimed_by", columns: claimColumns, rows: [][]driver.Value{[]driver.Value{"admin2", int64(1)}}}},
+		},
+		// claims time out
+		testCase{
+			expected: http.StatusNoContent, handler: claimNomination, ctx: userContext("admin1", true), target: "/review/claim?nomination=7",
+			queries: []fakeQuery{
+				fakeQuery{match: "SELECT r.claimed_by", columns: claimColumns, rows: [][]driver.Value{[]driver.Value{"admin2", int64(0)}}},
+				fakeQuery{match: "INSERT INTO nomination_reviews", affected: 1},
+			},
+			write: "INSERT INTO nomination_reviews",
+		},
+		testCase{
+			expected: http.StatusNoContent, handler: releaseNomination, ctx: userContext("admin1", true), target: "/review/release?nomination=7",
+			queries: []fakeQuery{
+				fakeQuery{match: "SELECT r.claimed_by", columns: claimColumns, rows: [][]driver.Value{[]driver.Value{"admin1", int64(1)}}},
+				fakeQuery{match: "UPDATE nomination_reviews SET claimed_by = NULL", affected: 1},
+			},
+			write: "UPDATE nomination_reviews SET claimed_by = NULL",
+		},
+		testCase{
+			expected: http.StatusConflict, handler: releaseNomination, ctx: userContext("admin1", true), target: "/review/release?nomination=7",
+			queries: []fakeQuery{fakeQuery{match: "SELECT r.claimed_by", columns: claimColumns, rows: [][]driver.Value{[]driver.Value{"admin2", int64(1)}}}},
+		},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		c.handler(w, httptest.NewRequest(http.MethodPost, c.target, nil).WithContext(c.ctx))
+		done()
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.target, c.expected, w.Code, w.Body.String())
+		}
+		if c.write != "" {
+			calls := db.called(c.write)
+			if len(calls) != 1 || !reflect.DeepEqual(calls[0].args[0], "7") {
+				t.Errorf("%s: expected one %q for nomination 7, got %+v", c.target, c.write, calls)
+			}
+		}
+	}
+}
+
+func TestReviewQueue(t *testing.T) {
+	submitted := time.Date(2019, time.February, 1, 12, 0, 0, 0, time.UTC)
+	_, done := useFakeDB(t, fakeQuery{
+		match:   "FROM nominations n LEFT JOIN nomination_reviews r",
+		columns: []string{"nomination_id", "nomination_partial_rin", "nomination_rcs_id", "valid", "page", "number", "rcs_id", "office_id", "date", "flagged", "problems", "warnings", "problem_codes", "claimed_by", "claimed_at"},
+		rows: [][]driver.Value{
+			[]driver.Value{int64(7), "123", "doej", nil, int64(1), int64(2), "lyonj4", int64(3), submitted, int64(1), `[]`, `["No graduation date on file; cohort needs manual review."]`, "no_graduation_date", nil, nil},
+			[]driver.Value{int64(8), "456", "smithj", nil, int64(1), int64(3), "lyonj4", int64(3), submitted, int64(0), `[]`, `[]`, "", "admin2", submitted},
+		},
+	})
+	defer done()
+
+	w := httptest.NewRecorder()
+	reviewQueue(w, httptest.NewRequest(http.MethodGet, "/review?problem=no_graduation_date", nil).WithContext(userContext("admin1", true)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	items := []struct {
+		ID           int      `json:"id"`
+		Flagged      bool     `json:"flagged"`
+		Warnings     []string `json:"warnings"`
+		ProblemCodes []string `json:"problem_codes"`
+		ClaimedBy    *string  `json:"claimed_by"`
+	}{}
+	err := json.NewDecoder(w.Body).Decode(&items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %+v", items)
+	}
+	if items[0].ID != 7 || !items[0].Flagged || !reflect.DeepEqual(items[0].ProblemCodes, []string{"no_graduation_date"}) || len(items[0].Warnings) != 1 {
+		t.Errorf("unexpected first item %+v", items[0])
+	}
+	if items[1].ProblemCodes == nil || len(items[1].ProblemCodes) != 0 || items[1].ClaimedBy == nil || *items[1].ClaimedBy != "admin2" {
+		t.Errorf("unexpected second item %+v", items[1])
+	}
+}
+
+func TestResolveReview(t *testing.T) {
+	current := fakeQuery{match: "SELECT valid, rcs_id FROM nominations", columns: []string{"valid", "rcs_id"}, rows: [][]driver.Value{[]driver.Value{nil, "lyonj4"}}}
+	updated := []fakeQuery{
+		fakeQuery{match: "UPDATE nominations SET nomination_partial_rin", affected: 1},
+		fakeQuery{match: "INSERT INTO audit_log", affected: 1},
+	}
+	// the event for the nomination, which is looked up for webhooks and again to publish it
+	nominationEvent := func(valid driver.Value) []fakeQuery {
+		return []fakeQuery{
+			fakeQuery{match: "SELECT rcs_id, office_id, page, valid FROM nominations", columns: []string{"rcs_id", "office_id", "page", "valid"}, rows: [][]driver.Value{[]driver.Value{"lyonj4", int64(3), int64(1), valid}}},
+			fakeQuery{match: "SELECT COUNT(*) FROM nominations", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(1)}}},
+		}
+	}
+	required := fakeQuery{match: "SELECT nominations_required FROM offices", columns: []string{"nominations_required"}, rows: [][]driver.Value{[]driver.Value{int64(50)}}}
+	count := func(n int64) fakeQuery {
+		return fakeQuery{match: "SUM(n.valid = true)", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{n}}}
+	}
+
+	type testCase struct {
+		body    string
+		queries []fakeQuery
+		// resolved is whether the nomination should leave the review queue
+		resolved bool
+	}
+	cases := []testCase{
+		// an admin decides a flagged nomination is valid
+		testCase{
+			body: `{"id": 7, "rin": "123", "rcs": "doej", "valid": true, "page": 1, "number": 2}`,
+			queries: concatQueries(
+				[]fakeQuery{current, count(0)}, updated,
+				[]fakeQuery{fakeQuery{match: "UPDATE nomination_reviews SET flagged = false", affected: 1}},
+				nominationEvent(int64(1)),
+				[]fakeQuery{count(1), required, fakeQuery{match: "INSERT INTO webhook_deliveries", affected: 1}},
+				nominationEvent(int64(1)),
+			),
+			resolved: true,
+		},
+		// fixing a typo without deciding leaves it in the queue
+		testCase{
+			body: `{"id": 7, "rin": "124", "rcs": "doej", "valid": null, "page": 1, "number": 2}`,
+			queries: concatQueries(
+				[]fakeQuery{current}, updated,
+				nominationEvent(nil), []fakeQuery{required},
+				nominationEvent(nil),
+			),
+		},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		w := httptest.NewRecorder()
+		modifyNomination(w, httptest.NewRequest(http.MethodPut, "/?nomination=7", strings.NewReader(c.body)).WithContext(userContext("admin1", true)))
+		done()
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", c.body, w.Code, w.Body.String())
+			continue
+		}
+		calls := db.called("UPDATE nomination_reviews SET flagged = false, claimed_by = NULL, claimed_at = NULL")
+		if resolved := len(calls) == 1 && reflect.DeepEqual(calls[0].args, []driver.Value{int64(7)}); resolved != c.resolved {
+			t.Errorf("%s: expected resolved to be %v, got %+v", c.body, c.resolved, calls)
+		}
+	}
+}
+
+func TestSaveValidation(t *testing.T) {
+	type testCase struct {
+		vn ValidNomination
+		// digest is whether the candidate should be sent a digest
+		digest bool
+	}
+	cases := []testCase{
+		testCase{vn: ValidNomination{Valid: true}},
+		testCase{vn: ValidNomination{Valid: true, Warnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from entry date.")}}},
+		testCase{vn: ValidNomination{Valid: false, Problems: Problems{problem(codeInvalidRCS, "Invalid RCS.")}}, digest: true},
+	}
+
+	for _, c := range cases {
+		queries := []fakeQuery{fakeQuery{match: "INSERT INTO nomination_reviews", affected: 1}}
+		if c.digest {
+			queries = append(queries, fakeQuery{match: "INSERT INTO pending_digests", affected: 1})
+		}
+		db, done := useFakeDB(t, queries...)
+		conn, err := getDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = saveValidation(conn, "LyonJ4", 7, c.vn)
+		conn.Close()
+		done()
+
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", c.vn, err)
+		}
+		calls := db.called("INSERT INTO pending_digests")
+		if digest := len(calls) == 1 && calls[0].args[0] == "lyonj4"; digest != c.digest {
+			t.Errorf("%+v: expected digest to be %v, got %+v", c.vn, c.digest, calls)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errSessionNotFound = errors.New("session not found")
+var errSessionExpired = errors.New("session expired")
+
+// sessionCacheTTL is how long a session loaded from the database is trusted before it is loaded
+// again. A session deleted by elections without calling POST /logout keeps working until then.
+var sessionCacheTTL = 30 * time.Second
+
+// cachedSession is a valid session and the time at which it must be loaded again.
+type cachedSession struct {
+	data   sessionData
+	reload time.Time
+}
+
+// sessionCache holds recently loaded sessions, keyed by session ID, so that every request
+// doesn't need a database query to authenticate.
+type sessionCache struct {
+	mu       sync.Mutex
+	sessions map[string]cachedSession
+}
+
+var sessions = newSessionCache()
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{sessions: map[string]cachedSession{}}
+}
+
+// get returns the cached session data for a session ID, if it is cached and still fresh.
+func (c *sessionCache) get(sessionID string, now time.Time) (sessionData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.sessions[sessionID]
+	if !ok {
+		return sessionData{}, false
+	}
+	if !now.Before(cached.reload) {
+		delete(c.sessions, sessionID)
+		return sessionData{}, false
+	}
+	return cached.data, true
+}
+
+// put caches session data until the TTL passes or the session expires, whichever is first.
+func (c *sessionCache) put(sessionID string, data sessionData, expires time.Time, now time.Time) {
+	reload := now.Add(sessionCacheTTL)
+	if expires.Before(reload) {
+		reload = expires
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// drop anything stale so abandoned sessions don't pile up
+	for id, cached := range c.sessions {
+		if !now.Before(cached.reload) {
+			delete(c.sessions, id)
+		}
+	}
+	c.sessions[sessionID] = cachedSession{data: data, reload: reload}
+}
+
+// invalidate removes a session from the cache.
+func (c *sessionCache) invalidate(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, sessionID)
+}
+
+// checkSessionRow returns why a session can't be used, given the result of looking up its row and
+// the row's expires column (maintained by express-mysql-session), or nil if it can.
+func checkSessionRow(err error, expiresUnix int64, now time.Time) error {
+	if err == sql.ErrNoRows {
+		return errSessionNotFound
+	} else if err != nil {
+		return err
+	}
+	if !now.Before(time.Unix(expiresUnix, 0)) {
+		return errSessionExpired
+	}
+	return nil
+}
+
+// loadSession returns the data for a session, from the cache if possible, so that most requests
+// don't need to look the session up in the database.
+func loadSession(sessionID string) (sessionData, error) {
+	now := time.Now()
+	if sd, ok := sessions.get(sessionID, now); ok {
+		return sd, nil
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return sessionData{}, err
+	}
+	defer db.Close()
+
+	row := db.QueryRow("SELECT data, expires FROM sessions WHERE session_id = ?", sessionID)
+	var jsonData []byte
+	var expiresUnix int64
+	err = checkSessionRow(row.Scan(&jsonData, &expiresUnix), expiresUnix, now)
+	if err != nil {
+		return sessionData{}, err
+	}
+
+	sd := sessionData{}
+	err = json.Unmarshal(jsonData, &sd)
+	if err != nil {
+		return sessionData{}, err
+	}
+
+	sessions.put(sessionID, sd, time.Unix(expiresUnix, 0), now)
+	return sd, nil
+}
+
+// logout ends the session in the request's cookie, deleting it from the database and the cache
+// so it stops working immediately. Elections should call this when a user logs out.
+func logout(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("connect.sid")
+	if err == http.ErrNoCookie {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+	valid, err := verifyCookie(cookie)
+	if err != nil || !valid {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+	sessionID, _, err := splitCookie(cookie)
+	if err != nil {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	sessions.invalidate(sessionID)
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM sessions WHERE session_id = ?", sessionID)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
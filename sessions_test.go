@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	now := time.Now()
+	sd := sessionData{CASUser: "lyonj4", Authenticated: true}
+
+	cache := newSessionCache()
+	cache.put("fresh", sd, now.Add(time.Hour), now)
+	cache.put("expiring", sd, now.Add(time.Second), now)
+	cache.put("revoked", sd, now.Add(time.Hour), now)
+	cache.invalidate("revoked")
+
+	type testCase struct {
+		expected  bool
+		sessionID string
+		at        time.Time
+	}
+	cases := []testCase{
+		testCase{expected: true, sessionID: "fresh", at: now},
+		testCase{expected: false, sessionID: "missing", at: now},
+		testCase{expected: false, sessionID: "revoked", at: now},
+		testCase{expected: true, sessionID: "expiring", at: now},
+		testCase{expected: false, sessionID: "expiring", at: now.Add(2 * time.Second)},
+		testCase{expected: false, sessionID: "fresh", at: now.Add(sessionCacheTTL)},
+	}
+
+	for _, c := range cases {
+		actual, ok := cache.get(c.sessionID, c.at)
+		if ok != c.expected {
+			t.Errorf("%s: expected cached %t, got %t", c.sessionID, c.expected, ok)
+		}
+		if ok && actual != sd {
+			t.Errorf("%s: expected %+v, got %+v", c.sessionID, sd, actual)
+		}
+	}
+}
+
+func TestCheckSessionRow(t *testing.T) {
+	now := time.Now()
+	dbErr := errors.New("connection refused")
+
+	type testCase struct {
+		expected error
+		err      error
+		expires  time.Time
+	}
+	cases := []testCase{
+		testCase{expected: nil, expires: now.Add(time.Hour)},
+		testCase{expected: errSessionExpired, expires: now},
+		testCase{expected: errSessionExpired, expires: now.Add(-time.Hour)},
+		testCase{expected: errSessionNotFound, err: sql.ErrNoRows},
+		testCase{expected: dbErr, err: dbErr},
+	}
+
+	for _, c := range cases {
+		actual := checkSessionRow(c.err, c.expires.Unix(), now)
+		if actual != c.expected {
+			t.Errorf("expected %v for %v expiring %s, got %v", c.expected, c.err, c.expires, actual)
+		}
+	}
+}
+
+func TestLoadSession(t *testing.T) {
+	defer func() { sessions = newSessionCache() }()
+	sessions = newSessionCache()
+	sessionColumns := []string{"data", "expires"}
+	expires := time.Now().Add(time.Hour).Unix()
+
+	type testCase struct {
+		expected  error
+		sessionID string
+		queries   []fakeQuery
+		// loaded is whether the session should be looked up in the database
+		loaded bool
+	}
+	cases := []testCase{
+		testCase{
+			expected: errSessionNotFound, sessionID: "missing", loaded: true,
+			queries: []fakeQuery{fakeQuery{match: "FROM sessions", columns: sessionColumns, rows: [][]driver.Value{}}},
+		},
+		testCase{
+			expected: errSessionExpired, sessionID: "expired", loaded: true,
+			queries: []fakeQuery{fakeQuery{match: "FROM sessions", columns: sessionColumns, rows: [][]driver.Value{[]driver.Value{[]byte(`{}`), time.Now().Add(-time.Hour).Unix()}}}},
+		},
+		testCase{
+			sessionID: "abc", loaded: true,
+			queries: []fakeQuery{fakeQuery{match: "FROM sessions", columns: sessionColumns, rows: [][]driver.Value{[]driver.Value{[]byte(`{"cas_user": "lyonj4"}`), expires}}}},
+		},
+		// the session was just loaded, so it comes from the cache
+		testCase{sessionID: "abc"},
+	}
+
+	for _, c := range cases {
+		db, done := useFakeDB(t, c.queries...)
+		sd, err := loadSession(c.sessionID)
+		done()
+
+		if err != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.sessionID, c.expected, err)
+		}
+		if err == nil && sd.CASUser != "lyonj4" {
+			t.Errorf("%s: unexpected session %+v", c.sessionID, sd)
+		}
+		if loaded := len(db.called("FROM sessions")) == 1; loaded != c.loaded {
+			t.Errorf("%s: expected loaded to be %v", c.sessionID, c.loaded)
+		}
+	}
+}
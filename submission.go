@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxNominationsPerPage is how many lines a paper nomination sheet has
+const maxNominationsPerPage = 25
+
+var partialRINPattern = regexp.MustCompile(`^[0-9]{3}$`)
+var rcsIDPattern = regexp.MustCompile(`^[a-z]+[0-9]*$`)
+
+// lineError describes a problem with one line of a submitted nomination page.
+// Index is the position of the line in the submitted list.
+type lineError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// checkLines checks the format of each line of a nomination page before it is inserted.
+// It doesn't check whether the nominator is eligible; that's what the validators are for.
+func checkLines(nominations []Nomination) []lineError {
+	errs := []lineError{}
+	seenNumbers := map[int]int{}
+
+	for i, nomination := range nominations {
+		if !partialRINPattern.MatchString(nomination.RIN) {
+			errs = append(errs, lineError{Index: i, Field: "rin", Message: "Partial RIN must be exactly three digits."})
+		}
+
+		rcs := strings.ToLower(strings.TrimSpace(nomination.RcsID))
+		if rcs == "" {
+			errs = append(errs, lineError{Index: i, Field: "rcs", Message: "RCS ID is missing."})
+		} else if !rcsIDPattern.MatchString(rcs) {
+			errs = append(errs, lineError{Index: i, Field: "rcs", Message: "RCS ID is not in a valid format."})
+		}
+
+		if nomination.Number < 1 || nomination.Number > maxNominationsPerPage {
+			errs = append(errs, lineError{Index: i, Field: "number", Message: "Line number must be between 1 and " + strconv.Itoa(maxNominationsPerPage) + "."})
+		} else if _, ok := seenNumbers[nomination.Number]; ok {
+			errs = append(errs, lineError{Index: i, Field: "number", Message: "Line number " + strconv.Itoa(nomination.Number) + " is used more than once on this page."})
+		} else {
+			seenNumbers[nomination.Number] = i
+		}
+	}
+
+	return errs
+}
+
+// submissionResult is the response body when a nomination page is accepted. Warnings don't stop
+// the page from being stored, but the candidate should know about them.
+type submissionResult struct {
+	Page     int         `json:"page_number"`
+	Warnings []lineError `json:"warnings"`
+}
+
+// duplicateWarning is the message for a nominator who is already on one of the candidate's pages.
+const duplicateWarning = "Nominator has already nominated this candidate for this office"
+
+// pageDuplicates warns about nominators who appear more than once on the same page.
+func pageDuplicates(nominations []Nomination) []lineError {
+	warnings := []lineError{}
+	seen := map[string]int{}
+
+	for i, nomination := range nominations {
+		rcs := strings.ToLower(strings.TrimSpace(nomination.RcsID))
+		if first, ok := seen[rcs]; ok {
+			warnings = append(warnings, lineError{Index: i, Field: "rcs", Message: duplicateWarning + " (line " + strconv.Itoa(nominations[first].Number) + " of this page)."})
+		} else {
+			seen[rcs] = i
+		}
+	}
+
+	return warnings
+}
+
+// existingDuplicates warns about nominators who already appear on the candidate's other pages
+// for the same office in the active election.
+func existingDuplicates(q queryer, rcs string, office string, nominations []Nomination) ([]lineError, error) {
+	existing, err := nominatorPositions(q, rcs, office)
+	if err != nil {
+		return []lineError{}, err
+	}
+	return knownDuplicates(existing, nominations), nil
+}
+
+// nominatorPositions returns where each nominator first appears on the candidate's pages for an
+// office in the active election, keyed by their lowercase RCS ID.
+func nominatorPositions(q queryer, rcs string, office string) (map[string]string, error) {
+	existing := map[string]string{}
+
+	rows, err := q.Query("SELECT nomination_rcs_id, page, number FROM nominations WHERE rcs_id = ? AND office_id = ? AND election_id = "+activeElectionQuery+" ORDER BY page, number", rcs, office)
+	if err != nil {
+		return existing, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nominator string
+		var page, number int
+		err = rows.Scan(&nominator, &page, &number)
+		if err != nil {
+			return existing, err
+		}
+		addNominatorPosition(existing, nominator, page, number)
+	}
+	return existing, rows.Err()
+}
+
+// addNominatorPosition records where a nominator appears, unless they were already found earlier.
+func addNominatorPosition(positions map[string]string, nominator string, page int, number int) {
+	nominator = strings.ToLower(strings.TrimSpace(nominator))
+	if _, ok := positions[nominator]; !ok {
+		positions[nominator] = "page " + strconv.Itoa(page) + ", line " + strconv.Itoa(number)
+	}
+}
+
+// knownDuplicates warns about nominators who appear in positions.
+func knownDuplicates(positions map[string]string, nominations []Nomination) []lineError {
+	warnings := []lineError{}
+	for i, nomination := range nominations {
+		rcs := strings.ToLower(strings.TrimSpace(nomination.RcsID))
+		if where, ok := positions[rcs]; ok {
+			warnings = append(warnings, lineError{Index: i, Field: "rcs", Message: duplicateWarning + " (" + where + ")."})
+		}
+	}
+	return warnings
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// insertPage adds nominations as a new page for a candidate and office in the active election,
+// numbered after the highest existing page, and records it in the audit log. It returns the page number.
+func insertPage(ctx context.Context, tx *sql.Tx, rcs string, office string, nominations []Nomination) (int, error) {
+	// figure out the highest existing page number and add 1 to it
+	prevPage, err := lastPage(tx, rcs, office)
+	if err != nil {
+		return 0, err
+	}
+	pageNum := prevPage + 1
+
+	// loop over provided nominations and insert
+	for _, nomination := range nominations {
+		_, err = tx.Exec("INSERT INTO nominations (rcs_id, office_id, nomination_partial_rin, nomination_rcs_id, page, number, election_id) VALUES (?, ?, ?, ?, ?, ?, "+activeElectionQuery+");", rcs, office, nomination.RIN, strings.ToLower(strings.TrimSpace(nomination.RcsID)), pageNum, nomination.Number)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = recordAudit(ctx, tx, auditEntry{
+		Action:       auditSubmitPage,
+		CandidateRCS: rcs,
+		Details:      fmt.Sprintf("office %s, page %d, %d nominations", office, pageNum, len(nominations)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pageNum, nil
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// lastPage returns the highest page number a candidate has for an office in the active election,
+// or 0 if they have none.
+func lastPage(q rowQueryer, rcs string, office string) (int, error) {
+	row := q.QueryRow("SELECT COALESCE(MAX(page), 0) FROM nominations WHERE rcs_id = ? and office_id = ? and election_id = "+activeElectionQuery, rcs, office)
+	var page int
+	err := row.Scan(&page)
+	return page, err
+}
+
+// checkOffice returns a reason the office can't be nominated for, or an empty string if it can.
+// Offices must be in the active election, enabled, and require nominations.
+func checkOffice(q rowQueryer, office string) (string, error) {
+	if _, err := strconv.Atoi(office); err != nil {
+		return "Office ID must be a number.", nil
+	}
+
+	row := q.QueryRow("SELECT nominations_required, disabled FROM offices WHERE office_id = ? AND election_id = "+activeElectionQuery, office)
+	var required int
+	var disabled bool
+	err := row.Scan(&required, &disabled)
+	if err == sql.ErrNoRows {
+		return "Office does not exist in the active election.", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if disabled || required <= 0 {
+		return "Office is not accepting nominations.", nil
+	}
+	return "", nil
+}
+
+// writeSubmissionError rejects a nomination page, listing the problems with its lines if there are any.
+func writeSubmissionError(w http.ResponseWriter, r *http.Request, field string, message string, lines []lineError) {
+	sendError(w, r, http.StatusUnprocessableEntity, apiError{Code: "invalid_nominations", Message: message, Field: field, Lines: lines})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckLines(t *testing.T) {
+	type testCase struct {
+		expected    []lineError
+		nominations []Nomination
+	}
+	cases := []testCase{
+		testCase{
+			expected: []lineError{},
+			nominations: []Nomination{
+				Nomination{RIN: "777", RcsID: "lyonj4", Number: 1},
+				Nomination{RIN: "999", RcsID: "KOCHMS", Number: 2},
+			},
+		},
+		testCase{
+			expected: []lineError{
+				lineError{Index: 0, Field: "rin", Message: "Partial RIN must be exactly three digits."},
+				lineError{Index: 1, Field: "rin", Message: "Partial RIN must be exactly three digits."},
+				lineError{Index: 2, Field: "rin", Message: "Partial RIN must be exactly three digits."},
+			},
+			nominations: []Nomination{
+				Nomination{RIN: "77", RcsID: "lyonj4", Number: 1},
+				Nomination{RIN: "7777", RcsID: "kochms", Number: 2},
+				Nomination{RIN: "7a7", RcsID: "smithj", Number: 3},
+			},
+		},
+		testCase{
+			expected: []lineError{
+				lineError{Index: 0, Field: "rcs", Message: "RCS ID is missing."},
+				lineError{Index: 1, Field: "rcs", Message: "RCS ID is not in a valid format."},
+			},
+			nominations: []Nomination{
+				Nomination{RIN: "777", RcsID: " ", Number: 1},
+				Nomination{RIN: "999", RcsID: "4lyonj", Number: 2},
+			},
+		},
+		testCase{
+			expected: []lineError{
+				lineError{Index: 0, Field: "number", Message: "Line number must be between 1 and 25."},
+				lineError{Index: 1, Field: "number", Message: "Line number must be between 1 and 25."},
+				lineError{Index: 3, Field: "number", Message: "Line number 3 is used more than once on this page."},
+			},
+			nominations: []Nomination{
+				Nomination{RIN: "777", RcsID: "lyonj4", Number: -1},
+				Nomination{RIN: "999", RcsID: "kochms", Number: 26},
+				Nomination{RIN: "123", RcsID: "smithj", Number: 3},
+				Nomination{RIN: "456", RcsID: "doej", Number: 3},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		actual := checkLines(c.nominations)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("expected %+v, got %+v", c.expected, actual)
+		}
+	}
+}
+
+func TestPageDuplicates(t *testing.T) {
+	nominations := []Nomination{
+		Nomination{RIN: "777", RcsID: "lyonj4", Number: 1},
+		Nomination{RIN: "999", RcsID: "kochms", Number: 2},
+		Nomination{RIN: "777", RcsID: "LYONJ4", Number: 3},
+		Nomination{RIN: "999", RcsID: "kochms", Number: 4},
+	}
+	expected := []lineError{
+		lineError{Index: 2, Field: "rcs", Message: "Nominator has already nominated this candidate for this office (line 1 of this page)."},
+		lineError{Index: 3, Field: "rcs", Message: "Nominator has already nominated this candidate for this office (line 2 of this page)."},
+	}
+
+	actual := pageDuplicates(nominations)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
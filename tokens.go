@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// scope limits what an API token can be used for
+type scope string
+
+const (
+	scopeReadCounts      scope = "counts:read"
+	scopeReadNominations scope = "nominations:read"
+	scopeValidate        scope = "validate"
+	scopeWrite           scope = "nominations:write"
+	// scopeAdmin lets a token act as an admin. Without it, tokens only have the rights of a
+	// logged-out user within their other scopes.
+	scopeAdmin scope = "admin"
+)
+
+var allScopes = []scope{scopeReadCounts, scopeReadNominations, scopeValidate, scopeWrite, scopeAdmin}
+
+const scopesKey = contextKey("scopes")
+
+// apiToken describes an issued token. The token itself is only included when it is created.
+type apiToken struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []scope    `json:"scopes"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	Token     string     `json:"token,omitempty"`
+}
+
+// parseScopes splits a comma-separated list of scopes, returning false if any are unknown.
+func parseScopes(s string) ([]scope, bool) {
+	scopes := []scope{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, sc := range allScopes {
+			if scope(name) == sc {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return scopes, false
+		}
+		scopes = append(scopes, scope(name))
+	}
+	return scopes, true
+}
+
+func joinScopes(scopes []scope) string {
+	names := []string{}
+	for _, sc := range scopes {
+		names = append(names, string(sc))
+	}
+	return strings.Join(names, ",")
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of a token, which is what's stored in the database.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, if there is one.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[len("Bearer "):])
+}
+
+// Look up token by its hash and attach its identity and scopes to context.
+func contextFromToken(ctx context.Context, token string) (context.Context, error) {
+	db, err := getDB()
+	if err != nil {
+		return ctx, err
+	}
+	defer db.Close()
+
+	row := db.QueryRow("SELECT name, scopes FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL", hashToken(token))
+	var name, scopeList string
+	err = row.Scan(&name, &scopeList)
+	if err != nil {
+		return ctx, err
+	}
+	scopes, _ := parseScopes(scopeList)
+	return tokenContext(ctx, name, scopes), nil
+}
+
+// tokenContext attaches a token's identity and scopes to context. Tokens only act as admins if they
+// have the admin scope, and even then only within their other scopes.
+func tokenContext(ctx context.Context, name string, scopes []scope) context.Context {
+	ctx = context.WithValue(ctx, casUserKey, "token:"+name)
+	ctx = context.WithValue(ctx, adminKey, hasScope(scopes, scopeAdmin))
+	ctx = context.WithValue(ctx, authenticatedKey, true)
+	ctx = context.WithValue(ctx, scopesKey, scopes)
+	return ctx
+}
+
+// hasScope returns whether scopes includes the given scope.
+func hasScope(scopes []scope, sc scope) bool {
+	for _, s := range scopes {
+		if s == sc {
+			return true
+		}
+	}
+	return false
+}
+
+// scopesFromContext returns the scopes of the token that authenticated the request.
+// ok is false if the request wasn't authenticated with a token.
+func scopesFromContext(ctx context.Context) (scopes []scope, ok bool) {
+	scopes, ok = ctx.Value(scopesKey).([]scope)
+	return scopes, ok
+}
+
+// requireScope rejects token-authenticated requests whose token lacks the given scope.
+// Requests authenticated with a session cookie are passed through to the handler's own checks.
+func requireScope(required scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, ok := scopesFromContext(r.Context())
+			if ok {
+				if !hasScope(scopes, required) {
+					writeStatus(w, r, http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sessionAdmin returns whether the request comes from an admin logged in with a session.
+// Tokens can't be used to manage tokens.
+func sessionAdmin(ctx context.Context) bool {
+	_, token := scopesFromContext(ctx)
+	return adminFromContext(ctx) && !token
+}
+
+// createToken issues a new API token. The body is a JSON object with a name and a list of scopes.
+// The token is only ever returned in this response.
+// Requires authorization, and only admins logged in with a session can use it.
+func createToken(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	req := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{}
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&req)
+	if err != nil {
+		log.Printf("unable to decode JSON: %s", err.Error())
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		writeMissing(w, r, "name", "missing name")
+		return
+	}
+	scopes, ok := parseScopes(strings.Join(req.Scopes, ","))
+	if !ok || len(scopes) == 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, "scopes", "invalid scopes")
+		return
+	}
+
+	// generate token
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		log.Printf("unable to generate token: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	casUser := casUserFromContext(r.Context())
+	res, err := db.Exec("INSERT INTO api_tokens (name, token_hash, scopes, created_by) VALUES (?, ?, ?, ?)", req.Name, hashToken(token), joinScopes(scopes), casUser)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("unable to get token ID: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	resp := apiToken{
+		ID:        int(id),
+		Name:      req.Name,
+		Scopes:    scopes,
+		CreatedBy: casUser,
+		CreatedAt: time.Now(),
+		Token:     token,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	enc.Encode(resp)
+}
+
+// listTokens returns every issued API token, without the tokens themselves.
+// Requires authorization, and only admins logged in with a session can use it.
+func listTokens(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT token_id, name, scopes, created_by, created_at, revoked_at FROM api_tokens ORDER BY token_id")
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	tokens := []apiToken{}
+	for rows.Next() {
+		token := apiToken{}
+		var scopeList string
+		err = rows.Scan(&token.ID, &token.Name, &scopeList, &token.CreatedBy, &token.CreatedAt, &token.RevokedAt)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		token.Scopes, _ = parseScopes(scopeList)
+		tokens = append(tokens, token)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(tokens)
+}
+
+// revokeToken revokes the API token with the given ID. Revoked tokens stop working immediately.
+// Requires authorization, and only admins logged in with a session can use it.
+func revokeToken(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	tokenID := r.FormValue("id")
+	if tokenID == "" {
+		writeMissing(w, r, "id", "missing token ID")
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	res, err := db.Exec("UPDATE api_tokens SET revoked_at = NOW() WHERE token_id = ? AND revoked_at IS NULL", tokenID)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	type testCase struct {
+		expected []scope
+		ok       bool
+		scopes   string
+	}
+	cases := []testCase{
+		testCase{expected: []scope{}, ok: true, scopes: ""},
+		testCase{expected: []scope{scopeReadCounts}, ok: true, scopes: "counts:read"},
+		testCase{expected: []scope{scopeReadNominations, scopeWrite}, ok: true, scopes: "nominations:read, nominations:write"},
+		testCase{expected: []scope{scopeValidate, scopeAdmin}, ok: true, scopes: "validate,admin"},
+		testCase{expected: []scope{scopeValidate}, ok: false, scopes: "validate,root"},
+	}
+
+	for _, c := range cases {
+		actual, ok := parseScopes(c.scopes)
+		if ok != c.ok || !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%q: expected %v (%t), got %v (%t)", c.scopes, c.expected, c.ok, actual, ok)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	type testCase struct {
+		expected int
+		ctx      context.Context
+	}
+	cases := []testCase{
+		// session cookies are left to the handler
+		testCase{expected: http.StatusOK, ctx: unauthenticatedContext(context.Background())},
+		testCase{expected: http.StatusOK, ctx: context.WithValue(context.Background(), scopesKey, []scope{scopeReadCounts, scopeWrite})},
+		testCase{expected: http.StatusForbidden, ctx: context.WithValue(context.Background(), scopesKey, []scope{scopeReadCounts})},
+		testCase{expected: http.StatusForbidden, ctx: context.WithValue(context.Background(), scopesKey, []scope{})},
+	}
+
+	handler := requireScope(scopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(c.ctx)
+		handler.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Errorf("expected status %d, got %d", c.expected, w.Code)
+		}
+	}
+}
+
+func TestTokenContext(t *testing.T) {
+	type testCase struct {
+		expected bool
+		scopes   []scope
+	}
+	cases := []testCase{
+		testCase{expected: false, scopes: []scope{}},
+		testCase{expected: false, scopes: []scope{scopeReadNominations, scopeWrite}},
+		testCase{expected: true, scopes: []scope{scopeReadNominations, scopeAdmin}},
+	}
+
+	for _, c := range cases {
+		ctx := tokenContext(context.Background(), "export", c.scopes)
+		if adminFromContext(ctx) != c.expected {
+			t.Errorf("%v: expected admin %t, got %t", c.scopes, c.expected, adminFromContext(ctx))
+		}
+		if casUserFromContext(ctx) != "token:export" || sessionAdmin(ctx) {
+			t.Errorf("%v: expected token user without session admin rights", c.scopes)
+		}
+	}
+}
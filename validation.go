@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -14,17 +15,24 @@ type validationResponse struct {
 	Validation *ValidNomination `json:"validation"`
 	Office     *officeInfo      `json:"office"`
 	Nominator  *CMSInfo         `json:"nominator"`
+	// NameConfidence is how closely the provided name matches the nominator's records, from 0 to 1
+	NameConfidence *float64 `json:"name_confidence,omitempty"`
 }
 
+// ValidNomination is the result of validating a nomination. Problems make it invalid, while
+// Warnings are advisory notes for admins that don't affect Valid.
 type ValidNomination struct {
 	Valid    bool     `json:"valid"`
 	Problems Problems `json:"problems,omitempty"`
+	Warnings Problems `json:"warnings,omitempty"`
 }
 
 type officeInfo struct {
 	ID      int      `json:"id"`
 	Type    string   `json:"type"`
 	Cohorts []string `json:"cohorts"`
+	// MaxCandidatesPerNominator is how many candidates one student may nominate for this office; 0 means no limit.
+	MaxCandidatesPerNominator int `json:"max_candidates_per_nominator,omitempty"`
 }
 
 type nominationInfo struct {
@@ -36,9 +44,65 @@ type nominationInfo struct {
 }
 
 type Validator func(*nominationInfo, *CMSInfo, *officeInfo) Problems
-type Problem string
+
+// Problem is something a validator found wrong with a nomination. Code identifies the kind of
+// problem, so problems can be filtered on, while Message explains it to people. Problems are stored
+// and sent as just their messages.
+type Problem struct {
+	Code    string
+	Message string
+}
 type Problems []Problem
 
+// problem codes
+const (
+	codeInvalidRCS       = "invalid_rcs"
+	codeNotStudent       = "not_student"
+	codeNotUndergraduate = "not_undergraduate"
+	codeNotGraduate      = "not_graduate"
+	codeCohort           = "cohort"
+	codeNotGreek         = "not_greek"
+	codeGreek            = "greek"
+	codePartialRINFormat = "partial_rin_format"
+	codeRINMismatch      = "rin_mismatch"
+	codeNoRIN            = "no_rin"
+	codeName             = "name"
+	codeDuplicate        = "duplicate"
+	codeNominatorLimit   = "nominator_limit"
+	codeSelfNomination   = "self_nomination"
+	codeNoGraduationDate = "no_graduation_date"
+	codeValidatorError   = "validator_error"
+)
+
+func problem(code string, message string) Problem {
+	return Problem{Code: code, Message: message}
+}
+
+func (p Problem) String() string {
+	return p.Message
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Message)
+}
+
+// UnmarshalJSON reads a problem's message. Its code isn't stored with it, so it is left empty.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	*p = Problem{}
+	return json.Unmarshal(data, &p.Message)
+}
+
+// codes returns the distinct codes of all the problems.
+func (p Problems) codes() []string {
+	codes := []string{}
+	for _, problem := range p {
+		if problem.Code != "" && !contains(codes, problem.Code) {
+			codes = append(codes, problem.Code)
+		}
+	}
+	return codes
+}
+
 // equal returns whether all elements are shared (order doesn't matter)
 func (p Problems) equal(other Problems) bool {
 	if len(p) != len(other) {
@@ -67,7 +131,7 @@ func studentValidator(nomination *nominationInfo, nominator *CMSInfo, office *of
 
 	// check if nominator is student
 	if nominator.Type != "Student" {
-		problems = append(problems, "Not a student.")
+		problems = append(problems, problem(codeNotStudent, "Not a student."))
 	}
 
 	return problems
@@ -76,17 +140,23 @@ func studentValidator(nomination *nominationInfo, nominator *CMSInfo, office *of
 func cohortValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) Problems {
 	problems := Problems{}
 
-	if nominator == nil || office == nil || nominator.GraduationDate.IsZero() {
+	if nominator == nil || office == nil {
+		return problems
+	}
+
+	// Without a graduation or entry date, the class year can only come from class by credit.
+	// If that's missing too, the cohort needs manual review (see graduationDateValidator).
+	if nominator.entryCohort() == "" && !nominator.undergraduate() && !nominator.graduate() {
 		return problems
 	}
 
 	// undergrad and grad students
 	if strings.ToLower(office.Type) == "undergraduate" && !nominator.undergraduate() {
-		problems = append(problems, "Not an undergraduate student.")
+		problems = append(problems, problem(codeNotUndergraduate, "Not an undergraduate student."))
 		return problems
 	}
 	if strings.ToLower(office.Type) == "graduate" && !nominator.graduate() {
-		problems = append(problems, "Not a graduate student.")
+		problems = append(problems, problem(codeNotGraduate, "Not a graduate student."))
 		return problems
 	}
 
@@ -99,23 +169,47 @@ func cohortValidator(nomination *nominationInfo, nominator *CMSInfo, office *off
 		}
 	}
 	if !found {
-		problems = append(problems, "Cohorts not eligible for this office.")
+		problems = append(problems, problem(codeCohort, "Cohorts not eligible for this office."))
+	}
+
+	return problems
+}
+
+// graduationDateValidator is a warning validator that points out when CMS has no graduation date
+// for the nominator, so their cohort was inferred from their entry date or class by credit, or
+// couldn't be determined at all and needs to be checked by hand.
+func graduationDateValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) Problems {
+	problems := Problems{}
+
+	if nominator == nil || nominator.Type != "Student" || !nominator.GraduationDate.IsZero() {
+		return problems
+	}
+
+	if !nominator.EntryDate.IsZero() {
+		problems = append(problems, problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from entry date."))
+	} else if nominator.undergraduate() || nominator.graduate() {
+		problems = append(problems, problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from class by credit."))
+	} else {
+		problems = append(problems, needsReviewWarning)
 	}
 
 	return problems
 }
 
+// needsReviewWarning is the warning for nominators whose eligibility can't be checked automatically.
+var needsReviewWarning = problem(codeNoGraduationDate, "No graduation date on file; cohort needs manual review.")
+
 func greekIndependentValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) Problems {
 	problems := Problems{}
 
 	// Greek
 	if strings.ToLower(office.Type) == "greek" && !nominator.Greek {
-		problems = append(problems, "Not Greek-affiliated.")
+		problems = append(problems, problem(codeNotGreek, "Not Greek-affiliated."))
 	}
 
 	// Independent
 	if strings.ToLower(office.Type) == "independent" && nominator.Greek {
-		problems = append(problems, "Greek-affiliated.")
+		problems = append(problems, problem(codeGreek, "Greek-affiliated."))
 	}
 
 	return problems
@@ -129,22 +223,88 @@ func rinRCSMatchValidator(nomination *nominationInfo, nominator *CMSInfo, office
 	}
 
 	if len(nomination.PartialRIN) > 3 {
-		problems = append(problems, "Partial RIN value contains more than three digits.")
+		problems = append(problems, problem(codePartialRINFormat, "Partial RIN value contains more than three digits."))
 	}
 	if len(nomination.PartialRIN) < 3 {
-		problems = append(problems, "Partial RIN value contains less than three digits.")
+		problems = append(problems, problem(codePartialRINFormat, "Partial RIN value contains less than three digits."))
+	}
+	// CMS doesn't have a RIN for everyone
+	if len(nominator.RIN) < 3 {
+		problems = append(problems, problem(codeNoRIN, "No RIN on file."))
+		return problems
 	}
 	// rcs matches rin?
 	if nominator.RIN[len(nominator.RIN)-3:] != nomination.PartialRIN {
-		problems = append(problems, "Mismatched RIN digits.")
+		problems = append(problems, problem(codeRINMismatch, "Mismatched RIN digits."))
+	}
+
+	return problems
+}
+
+// selfNominationValidator checks whether the candidate is nominating themselves.
+// Whether this is a problem or only a warning depends on the election's rules.
+func selfNominationValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) Problems {
+	problems := Problems{}
+
+	if nomination == nil {
+		return problems
+	}
+
+	if strings.ToLower(strings.TrimSpace(nomination.RcsID)) == strings.ToLower(strings.TrimSpace(nomination.CandidateRCS)) {
+		problems = append(problems, problem(codeSelfNomination, "Candidate cannot nominate themselves."))
+	}
+
+	return problems
+}
+
+// severities of election rules
+const (
+	severityProblem = "problem"
+	severityWarning = "warning"
+)
+
+// selfNominationSeverity returns how the active election treats self-nominations.
+// Elections without rules treat them as problems.
+func selfNominationSeverity(db *sql.DB) (string, error) {
+	row := db.QueryRow("SELECT self_nomination FROM election_rules WHERE election_id = " + activeElectionQuery)
+	var severity string
+	err := row.Scan(&severity)
+	if err == sql.ErrNoRows {
+		return severityProblem, nil
+	} else if err != nil {
+		return "", err
+	}
+	if severity != severityWarning {
+		return severityProblem, nil
+	}
+	return severity, nil
+}
+
+// nameConfidenceThreshold is the name match confidence below which fuzzyNameValidator warns.
+const nameConfidenceThreshold = 0.8
+
+// fuzzyNameValidator is a warning validator that compares the name written on a nomination to
+// the nominator's Institute records, allowing for middle names, multiple surnames, hyphens,
+// diacritics and people who go by their middle name. Spelling differences are only a warning,
+// because handwritten names are often transcribed imperfectly.
+func fuzzyNameValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) Problems {
+	problems := Problems{}
+
+	// names are optional, since the site doesn't always collect them
+	if nomination == nil || nominator == nil || strings.TrimSpace(nomination.Name) == "" {
+		return problems
+	}
+
+	confidence := nameConfidence(nomination.Name, nominator.FirstName, nominator.MiddleName, nominator.LastName)
+	if confidence < nameConfidenceThreshold {
+		problems = append(problems, problem(codeName, fmt.Sprintf("Name does not closely match Institute records (%.0f%% match).", confidence*100)))
 	}
 
 	return problems
 }
 
 // nameValidator assumes format "Firstname Lastname", which is super limited and does not
-// properly handle everyone's names. This is not currently in use, as the site does not
-// collect names of nominators.
+// properly handle everyone's names. It is not in use; fuzzyNameValidator replaces it.
 func nameValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) Problems {
 	problems := Problems{}
 
@@ -153,13 +313,13 @@ func nameValidator(nomination *nominationInfo, nominator *CMSInfo, office *offic
 	}
 
 	if len(nomination.Name) == 0 {
-		problems = append(problems, "No name provided.")
+		problems = append(problems, problem(codeName, "No name provided."))
 		return problems
 	}
 
 	splitName := strings.Split(nomination.Name, " ")
 	if len(splitName) != 2 {
-		problems = append(problems, "Name not in recognized format.")
+		problems = append(problems, problem(codeName, "Name not in recognized format."))
 		return problems
 	}
 
@@ -167,17 +327,17 @@ func nameValidator(nomination *nominationInfo, nominator *CMSInfo, office *offic
 	lastName := strings.ToLower(splitName[1])
 
 	if firstName != strings.ToLower(nominator.FirstName) {
-		problems = append(problems, "First name does not match Institute records.")
+		problems = append(problems, problem(codeName, "First name does not match Institute records."))
 	}
 	if lastName != strings.ToLower(nominator.LastName) {
-		problems = append(problems, "Last name does not match Institute records.")
+		problems = append(problems, problem(codeName, "Last name does not match Institute records."))
 	}
 
 	return problems
 }
 
-// uniqueValidator checks for any other nominations that have the same RIN. It returns problems if another
-// nomination has a lower ID than this one (and therefore it is not the only one).
+// uniqueValidator checks for any other nominations in the active election that have the same RCS ID.
+// It returns problems if another nomination has a lower ID than this one (and therefore it is not the only one).
 // Because it needs database access, this validator needs to be called differently from the others,
 // and it can return an error.
 func uniqueValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) (Problems, error) {
@@ -194,21 +354,97 @@ func uniqueValidator(nomination *nominationInfo, nominator *CMSInfo, office *off
 	defer db.Close()
 
 	var count int
-	row := db.QueryRow("SELECT count(*) FROM nominations WHERE rcs_id = ? AND office_id = ? AND nomination_rcs_id = ? AND nomination_id < ?", nomination.CandidateRCS, office.ID, nomination.RcsID, nomination.ID)
+	row := db.QueryRow("SELECT count(*) FROM nominations WHERE rcs_id = ? AND office_id = ? AND nomination_rcs_id = ? AND nomination_id < ? AND election_id = "+activeElectionQuery, nomination.CandidateRCS, office.ID, nomination.RcsID, nomination.ID)
 	err = row.Scan(&count)
 	if err != nil {
 		return problems, err
 	}
 
 	if count > 0 {
-		problems = append(problems, "Nominator has already nominated this candidate for this office.")
+		problems = append(problems, problem(codeDuplicate, "Nominator has already nominated this candidate for this office."))
 	}
 
 	return problems, nil
 }
 
+// nominatorLimitValidator checks how many other candidates the nominator has already nominated for this
+// office, and returns problems if that's as many as the office allows. Like uniqueValidator, only
+// nominations with a lower ID than this one count, and it needs to be called separately.
+func nominatorLimitValidator(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) (Problems, error) {
+	problems := Problems{}
+
+	if nomination == nil || office == nil || office.MaxCandidatesPerNominator <= 0 {
+		return problems, nil
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return problems, err
+	}
+	defer db.Close()
+
+	// nominations already marked invalid don't count against the nominator
+	rows, err := db.Query("SELECT DISTINCT rcs_id FROM nominations WHERE office_id = ? AND nomination_rcs_id = ? AND rcs_id <> ? AND nomination_id < ? AND (valid IS NULL OR valid = true) AND election_id = "+activeElectionQuery+" ORDER BY rcs_id", office.ID, nomination.RcsID, nomination.CandidateRCS, nomination.ID)
+	if err != nil {
+		return problems, err
+	}
+	defer rows.Close()
+
+	candidates := []string{}
+	for rows.Next() {
+		var candidate string
+		err = rows.Scan(&candidate)
+		if err != nil {
+			return problems, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	if err = rows.Err(); err != nil {
+		return problems, err
+	}
+
+	if len(candidates) >= office.MaxCandidatesPerNominator {
+		problems = append(problems, problem(codeNominatorLimit, fmt.Sprintf("Nominator has already nominated the maximum number of candidates for this office (%d): %s.", office.MaxCandidatesPerNominator, strings.Join(candidates, ", "))))
+	}
+
+	return problems, nil
+}
+
+// officeNominatorLimit returns how many candidates one student may nominate for an office, or 0 for no limit.
+func officeNominatorLimit(db *sql.DB, officeID int) (int, error) {
+	row := db.QueryRow("SELECT max_candidates_per_nominator FROM office_rules WHERE office_id = ?", officeID)
+	var limit sql.NullInt64
+	err := row.Scan(&limit)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return int(limit.Int64), nil
+}
+
+// validatorPanicProblem is reported in place of whatever a validator would have found, if it panics.
+var validatorPanicProblem = problem(codeValidatorError, "Unable to check this nomination automatically; needs manual review.")
+
+// runValidator runs a single validator, recovering from any panic (e.g. on unexpected CMS data)
+// so one bad record doesn't fail the whole request. A panic is reported as a problem.
+func runValidator(validator Validator, nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) (problems Problems) {
+	defer func() {
+		if r := recover(); r != nil {
+			nomID := 0
+			if nomination != nil {
+				nomID = nomination.ID
+			}
+			log.Printf("validator panicked on nomination %d: %v", nomID, r)
+			problems = Problems{validatorPanicProblem}
+		}
+	}()
+	return validator(nomination, nominator, office)
+}
+
 // validate uses election-specific info validators to validate the provided information.
 // It takes in existing Problems (may be empty), and it returns a ValidNomination struct.
+// Validators in warningValidators report warnings instead of problems, which don't affect validity.
 func validate(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo, problems Problems) ValidNomination {
 	validators := []Validator{
 		studentValidator,
@@ -216,9 +452,17 @@ func validate(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo
 		greekIndependentValidator,
 		rinRCSMatchValidator,
 	}
+	warningValidators := []Validator{
+		graduationDateValidator,
+		fuzzyNameValidator,
+	}
 
 	for _, validator := range validators {
-		problems = append(problems, validator(nomination, nominator, office)...)
+		problems = append(problems, runValidator(validator, nomination, nominator, office)...)
+	}
+	warnings := Problems{}
+	for _, validator := range warningValidators {
+		warnings = append(warnings, runValidator(validator, nomination, nominator, office)...)
 	}
 
 	vn := ValidNomination{}
@@ -228,30 +472,32 @@ func validate(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo
 		vn.Valid = false
 	}
 	vn.Problems = problems
+	vn.Warnings = warnings
 
 	return vn
 }
 
 // validateNomination returns information about whether a nomination is valid or invalid.
+// The result is saved so the nomination can be found in the review queue.
 // It requires authorization, and only admins have permission to use it.
 // TODO: check if the nomination is a duplicate of an existing one
 func validateNomination(w http.ResponseWriter, r *http.Request) {
 	// check if this user has permission to do this
 	admin := adminFromContext(r.Context())
 	if !admin {
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		writeStatus(w, r, http.StatusUnauthorized)
 		return
 	}
 
 	// validate provided input
 	office := r.FormValue("office")
 	if office == "" {
-		http.Error(w, "missing office", http.StatusUnprocessableEntity)
+		writeMissing(w, r, "office", "missing office")
 		return
 	}
 	candidateRCS := r.FormValue("candidate_rcs")
 	if candidateRCS == "" {
-		http.Error(w, "missing candidate RCS", http.StatusUnprocessableEntity)
+		writeMissing(w, r, "candidate_rcs", "missing candidate RCS")
 		return
 	}
 
@@ -261,7 +507,7 @@ func validateNomination(w http.ResponseWriter, r *http.Request) {
 	nomID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
 	if err != nil {
 		log.Printf("unable to parse int: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		writeError(w, r, http.StatusUnprocessableEntity, "id", "id must be a number")
 		return
 	}
 
@@ -269,45 +515,59 @@ func validateNomination(w http.ResponseWriter, r *http.Request) {
 	nomination.PartialRIN = rin
 	nomination.ID = int(nomID)
 	nomination.RcsID = r.FormValue("rcs")
+	nomination.Name = r.FormValue("name")
 	nomination.CandidateRCS = candidateRCS
 
 	// get office info
 	db, err := getDB()
 	if err != nil {
 		log.Printf("unable to get database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 	defer db.Close()
 	row := db.QueryRow("SELECT type FROM offices WHERE office_id = ? AND election_id = "+activeElectionQuery, office)
 	if err != nil {
 		log.Printf("unable to query database: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 
 	var officeType string
 	err = row.Scan(&officeType)
 	if err == sql.ErrNoRows {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	} else if err != nil {
 		log.Printf("unable to scan: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 	officeInfo := officeInfoFromType(officeType)
 	officeID, err := strconv.ParseInt(office, 10, 64)
 	if err != nil {
 		log.Printf("unable to parse int: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		writeError(w, r, http.StatusUnprocessableEntity, "office", "office must be a number")
 		return
 	}
 	officeInfo.ID = int(officeID)
+	officeInfo.MaxCandidatesPerNominator, err = officeNominatorLimit(db, officeInfo.ID)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
 
 	nominator, err := cmsInfoRCS(nomination.RcsID)
 	if err == errInfoNotFound {
-		vn := ValidNomination{Valid: false, Problems: Problems{"Invalid RCS."}}
+		vn := ValidNomination{Valid: false, Problems: Problems{problem(codeInvalidRCS, "Invalid RCS.")}}
+		err = saveValidation(db, candidateRCS, nomination.ID, vn)
+		if err != nil {
+			log.Printf("unable to save validation: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		publishValidation(db, candidateRCS, officeInfo.ID, nomination.ID, vn)
 		resp := validationResponse{
 			Validation: &vn,
 			Office:     &officeInfo,
@@ -325,7 +585,7 @@ func validateNomination(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		log.Printf("unable to get CMS info: %s", err.Error())
-		http.Error(w, "unable to get CMS info", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "", "unable to get CMS info")
 		return
 	}
 
@@ -333,16 +593,51 @@ func validateNomination(w http.ResponseWriter, r *http.Request) {
 	uniqueProblems, err := uniqueValidator(&nomination, &nominator, &officeInfo)
 	if err != nil {
 		log.Printf("unable to get CMS info: %s", err.Error())
-		http.Error(w, "unable to get CMS info", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "", "unable to get CMS info")
 		return
 	}
+	// as does nominatorLimitValidator
+	limitProblems, err := nominatorLimitValidator(&nomination, &nominator, &officeInfo)
+	if err != nil {
+		log.Printf("unable to check nominator limit: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	uniqueProblems = append(uniqueProblems, limitProblems...)
+
+	// self-nominations are either problems or warnings, depending on the election
+	selfSeverity, err := selfNominationSeverity(db)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	selfWarnings := Problems{}
+	if selfSeverity == severityWarning {
+		selfWarnings = append(selfWarnings, selfNominationValidator(&nomination, &nominator, &officeInfo)...)
+	} else {
+		uniqueProblems = append(uniqueProblems, selfNominationValidator(&nomination, &nominator, &officeInfo)...)
+	}
+
 	// validate the nomination
 	vn := validate(&nomination, &nominator, &officeInfo, uniqueProblems)
+	vn.Warnings = append(vn.Warnings, selfWarnings...)
+	err = saveValidation(db, candidateRCS, nomination.ID, vn)
+	if err != nil {
+		log.Printf("unable to save validation: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	publishValidation(db, candidateRCS, officeInfo.ID, nomination.ID, vn)
 	resp := validationResponse{
 		Validation: &vn,
 		Office:     &officeInfo,
 		Nominator:  &nominator,
 	}
+	if strings.TrimSpace(nomination.Name) != "" {
+		confidence := nameConfidence(nomination.Name, nominator.FirstName, nominator.MiddleName, nominator.LastName)
+		resp.NameConfidence = &confidence
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
@@ -353,10 +648,13 @@ func validateNomination(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// clock returns the current time for working out cohorts. Tests replace it with a fixed date.
+var clock = time.Now
+
 func officeInfoFromType(officeType string) officeInfo {
 	o := officeInfo{Type: strings.ToLower(officeType)}
 
-	year := time.Now().Year()
+	year := clock().Year()
 
 	if o.Type == "all" {
 		o.Cohorts = []string{"graduate"}
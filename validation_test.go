@@ -1,7 +1,10 @@
 package main
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -32,7 +35,7 @@ func TestValidation(t *testing.T) {
 			},
 		},
 		testCase{
-			expected: ValidNomination{Valid: false, Problems: Problems{"Not a student."}},
+			expected: ValidNomination{Valid: false, Problems: Problems{problem(codeNotStudent, "Not a student.")}},
 			nominator: &CMSInfo{
 				Type:           "Staff",
 				GraduationDate: cmsDate{Time: time.Time{}},
@@ -54,7 +57,7 @@ func TestValidation(t *testing.T) {
 			},
 		},
 		testCase{
-			expected: ValidNomination{Valid: false, Problems: Problems{"Not Greek-affiliated."}},
+			expected: ValidNomination{Valid: false, Problems: Problems{problem(codeNotGreek, "Not Greek-affiliated.")}},
 			nominator: &CMSInfo{
 				Type:           "Student",
 				Greek:          false,
@@ -90,7 +93,7 @@ func TestValidation(t *testing.T) {
 			},
 		},
 		testCase{
-			expected: ValidNomination{Valid: false, Problems: Problems{"Greek-affiliated."}},
+			expected: ValidNomination{Valid: false, Problems: Problems{problem(codeGreek, "Greek-affiliated.")}},
 			nominator: &CMSInfo{
 				Type:           "Student",
 				Greek:          true,
@@ -126,7 +129,7 @@ func TestValidation(t *testing.T) {
 			},
 		},
 		testCase{
-			expected: ValidNomination{Valid: false, Problems: Problems{"Not a graduate student."}},
+			expected: ValidNomination{Valid: false, Problems: Problems{problem(codeNotGraduate, "Not a graduate student.")}},
 			nominator: &CMSInfo{
 				Type:           "Student",
 				Greek:          true,
@@ -159,7 +162,7 @@ func TestStudentValidator(t *testing.T) {
 	}
 	cases := []testCase{
 		testCase{
-			expected: Problems{"Not a student."},
+			expected: Problems{problem(codeNotStudent, "Not a student.")},
 			nominator: &CMSInfo{
 				Type: "Employee",
 			},
@@ -194,7 +197,7 @@ func TestCohortValidator(t *testing.T) {
 	}
 	cases := []testCase{
 		testCase{
-			expected: Problems{"Not an undergraduate student."},
+			expected: Problems{problem(codeNotUndergraduate, "Not an undergraduate student.")},
 			nominator: &CMSInfo{
 				ClassByCredit:  "Graduate",
 				GraduationDate: createCMSDate("2018-01-01"),
@@ -211,7 +214,7 @@ func TestCohortValidator(t *testing.T) {
 			nomination: nil,
 		},
 		testCase{
-			expected: Problems{"Not a graduate student."},
+			expected: Problems{problem(codeNotGraduate, "Not a graduate student.")},
 			nominator: &CMSInfo{
 				ClassByCredit:  "Freshman",
 				GraduationDate: createCMSDate("2021-01-01"),
@@ -225,7 +228,7 @@ func TestCohortValidator(t *testing.T) {
 			nomination: nil,
 		},
 		testCase{
-			expected: Problems{"Cohorts not eligible for this office."},
+			expected: Problems{problem(codeCohort, "Cohorts not eligible for this office.")},
 			nominator: &CMSInfo{
 				ClassByCredit:  "Junior",
 				GraduationDate: createCMSDate("2019-01-01"),
@@ -314,7 +317,7 @@ func TestRinRCSMatchValidator(t *testing.T) {
 			office: nil,
 		},
 		testCase{
-			expected: Problems{"Mismatched RIN digits."},
+			expected: Problems{problem(codeRINMismatch, "Mismatched RIN digits.")},
 			nominator: &CMSInfo{
 				FirstName: "Joseph",
 				LastName:  "Lyon",
@@ -328,7 +331,7 @@ func TestRinRCSMatchValidator(t *testing.T) {
 		},
 		testCase{
 			expected: Problems{
-				"Partial RIN value contains more than three digits.", "Mismatched RIN digits.",
+				problem(codePartialRINFormat, "Partial RIN value contains more than three digits."), problem(codeRINMismatch, "Mismatched RIN digits."),
 			},
 			nominator: &CMSInfo{
 				FirstName: "Joseph",
@@ -342,7 +345,7 @@ func TestRinRCSMatchValidator(t *testing.T) {
 			office: nil,
 		},
 		testCase{
-			expected: Problems{"Partial RIN value contains more than three digits.", "Mismatched RIN digits."},
+			expected: Problems{problem(codePartialRINFormat, "Partial RIN value contains more than three digits."), problem(codeRINMismatch, "Mismatched RIN digits.")},
 			nominator: &CMSInfo{
 				FirstName: "Joseph",
 				LastName:  "Lyon",
@@ -354,6 +357,32 @@ func TestRinRCSMatchValidator(t *testing.T) {
 			},
 			office: nil,
 		},
+		testCase{
+			expected: Problems{problem(codeNoRIN, "No RIN on file.")},
+			nominator: &CMSInfo{
+				FirstName: "Joseph",
+				LastName:  "Lyon",
+				RIN:       "",
+			},
+			nomination: &nominationInfo{
+				Name:       "Joseph Lyon",
+				PartialRIN: "777",
+			},
+			office: nil,
+		},
+		testCase{
+			expected: Problems{problem(codePartialRINFormat, "Partial RIN value contains less than three digits."), problem(codeNoRIN, "No RIN on file.")},
+			nominator: &CMSInfo{
+				FirstName: "Joseph",
+				LastName:  "Lyon",
+				RIN:       "77",
+			},
+			nomination: &nominationInfo{
+				Name:       "Joseph Lyon",
+				PartialRIN: "77",
+			},
+			office: nil,
+		},
 	}
 
 	for _, c := range cases {
@@ -414,7 +443,7 @@ func TestNameValidator(t *testing.T) {
 			office: nil,
 		},
 		testCase{
-			expected: Problems{"First name does not match Institute records."},
+			expected: Problems{problem(codeName, "First name does not match Institute records.")},
 			nominator: &CMSInfo{
 				FirstName:  "Sidney",
 				MiddleName: "David",
@@ -426,7 +455,7 @@ func TestNameValidator(t *testing.T) {
 			office: nil,
 		},
 		testCase{
-			expected: Problems{"Last name does not match Institute records."},
+			expected: Problems{problem(codeName, "Last name does not match Institute records.")},
 			nominator: &CMSInfo{
 				FirstName:  "Sidney",
 				MiddleName: "David",
@@ -446,3 +475,336 @@ func TestNameValidator(t *testing.T) {
 		}
 	}
 }
+
+func TestSelfNominationValidator(t *testing.T) {
+	type testCase struct {
+		expected   Problems
+		nomination *nominationInfo
+		nominator  *CMSInfo
+		office     *officeInfo
+	}
+	cases := []testCase{
+		testCase{
+			expected: Problems{},
+			nomination: &nominationInfo{
+				RcsID:        "kochms",
+				CandidateRCS: "lyonj4",
+			},
+		},
+		testCase{
+			expected: Problems{problem(codeSelfNomination, "Candidate cannot nominate themselves.")},
+			nomination: &nominationInfo{
+				RcsID:        "LyonJ4",
+				CandidateRCS: "lyonj4",
+			},
+		},
+		testCase{
+			expected:   Problems{},
+			nomination: nil,
+		},
+	}
+
+	for _, c := range cases {
+		actual := selfNominationValidator(c.nomination, c.nominator, c.office)
+		if !actual.equal(c.expected) {
+			t.Errorf("expected %+v, got %+v", c.expected, actual)
+		}
+	}
+}
+
+func TestValidationWarnings(t *testing.T) {
+	type testCase struct {
+		expected   ValidNomination
+		nomination *nominationInfo
+		nominator  *CMSInfo
+		office     *officeInfo
+	}
+	cases := []testCase{
+		testCase{
+			expected: ValidNomination{Valid: true, Problems: Problems{}, Warnings: Problems{}},
+			nominator: &CMSInfo{
+				Type:           "Student",
+				GraduationDate: createCMSDate("2020-01-01"),
+			},
+			office: &officeInfo{Type: "2020", Cohorts: []string{"2020"}},
+		},
+		testCase{
+			expected: ValidNomination{
+				Valid:    true,
+				Problems: Problems{},
+				Warnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort needs manual review.")},
+			},
+			nominator: &CMSInfo{
+				Type: "Student",
+			},
+			office: &officeInfo{Type: "2020", Cohorts: []string{"2020"}},
+		},
+		testCase{
+			expected: ValidNomination{
+				Valid:    false,
+				Problems: Problems{problem(codeNotGreek, "Not Greek-affiliated.")},
+				Warnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort needs manual review.")},
+			},
+			nominator: &CMSInfo{
+				Type: "Student",
+			},
+			office: &officeInfo{Type: "greek", Cohorts: []string{"greek"}},
+		},
+	}
+
+	for _, c := range cases {
+		actual := validate(c.nomination, c.nominator, c.office, Problems{})
+
+		if actual.Valid != c.expected.Valid || !actual.Problems.equal(c.expected.Problems) || !actual.Warnings.equal(c.expected.Warnings) {
+			t.Errorf("expected %+v, got %+v", c.expected, actual)
+		}
+	}
+}
+
+func TestFuzzyNameValidator(t *testing.T) {
+	type testCase struct {
+		expected   Problems
+		nomination *nominationInfo
+		nominator  *CMSInfo
+		office     *officeInfo
+	}
+	nominator := &CMSInfo{
+		FirstName:  "Sidney",
+		MiddleName: "David",
+		LastName:   "Kochman",
+	}
+	cases := []testCase{
+		testCase{
+			expected:   Problems{},
+			nominator:  nominator,
+			nomination: &nominationInfo{Name: ""},
+		},
+		testCase{
+			expected:   Problems{},
+			nominator:  nominator,
+			nomination: &nominationInfo{Name: "sidney d. kochman"},
+		},
+		testCase{
+			expected:   Problems{problem(codeName, "Name does not closely match Institute records (57% match).")},
+			nominator:  nominator,
+			nomination: &nominationInfo{Name: "Sidney Lyon"},
+		},
+	}
+
+	for _, c := range cases {
+		actual := fuzzyNameValidator(c.nomination, c.nominator, c.office)
+		if !actual.equal(c.expected) {
+			t.Errorf("expected %+v, got %+v", c.expected, actual)
+		}
+	}
+}
+
+func TestCohortValidatorMissingGraduationDate(t *testing.T) {
+	type testCase struct {
+		expected         Problems
+		expectedWarnings Problems
+		nominator        *CMSInfo
+		office           *officeInfo
+	}
+	year := clock().Year()
+	cases := []testCase{
+		// entry date is used in place of graduation date
+		testCase{
+			expected:         Problems{},
+			expectedWarnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from entry date.")},
+			nominator: &CMSInfo{
+				Type:          "Student",
+				ClassByCredit: "Junior",
+				EntryDate:     createCMSDate("2016-08-25"),
+			},
+			office: &officeInfo{Type: "2020", Cohorts: []string{"2020"}},
+		},
+		testCase{
+			expected:         Problems{problem(codeCohort, "Cohorts not eligible for this office.")},
+			expectedWarnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from entry date.")},
+			nominator: &CMSInfo{
+				Type:      "Student",
+				EntryDate: createCMSDate("2017-08-25"),
+			},
+			office: &officeInfo{Type: "2020", Cohorts: []string{"2020"}},
+		},
+		// then class by credit
+		testCase{
+			expected:         Problems{},
+			expectedWarnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from class by credit.")},
+			nominator: &CMSInfo{
+				Type:          "Student",
+				ClassByCredit: "Sophomore",
+			},
+			office: &officeInfo{Type: "sophomore", Cohorts: []string{strconv.Itoa(year + 2)}},
+		},
+		testCase{
+			expected:         Problems{problem(codeCohort, "Cohorts not eligible for this office.")},
+			expectedWarnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from class by credit.")},
+			nominator: &CMSInfo{
+				Type:          "Student",
+				ClassByCredit: "Senior",
+			},
+			office: &officeInfo{Type: "sophomore", Cohorts: []string{strconv.Itoa(year + 2)}},
+		},
+		testCase{
+			expected:         Problems{problem(codeNotGraduate, "Not a graduate student.")},
+			expectedWarnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from class by credit.")},
+			nominator: &CMSInfo{
+				Type:          "Student",
+				ClassByCredit: "Freshman",
+			},
+			office: &officeInfo{Type: "graduate", Cohorts: []string{"graduate"}},
+		},
+		// and if there's nothing to go on, it needs manual review instead of silently passing
+		testCase{
+			expected:         Problems{},
+			expectedWarnings: Problems{problem(codeNoGraduationDate, "No graduation date on file; cohort needs manual review.")},
+			nominator: &CMSInfo{
+				Type: "Student",
+			},
+			office: &officeInfo{Type: "2020", Cohorts: []string{"2020"}},
+		},
+	}
+
+	for _, c := range cases {
+		actual := cohortValidator(nil, c.nominator, c.office)
+		if !actual.equal(c.expected) {
+			t.Errorf("expected %+v, got %+v", c.expected, actual)
+		}
+		actualWarnings := graduationDateValidator(nil, c.nominator, c.office)
+		if !actualWarnings.equal(c.expectedWarnings) {
+			t.Errorf("expected warnings %+v, got %+v", c.expectedWarnings, actualWarnings)
+		}
+	}
+}
+
+func TestRunValidatorRecovers(t *testing.T) {
+	panicky := func(nomination *nominationInfo, nominator *CMSInfo, office *officeInfo) Problems {
+		var missing []string
+		return Problems{problem(codeNotStudent, missing[0])}
+	}
+
+	actual := runValidator(panicky, &nominationInfo{ID: 1}, &CMSInfo{}, nil)
+	expected := Problems{validatorPanicProblem}
+	if !actual.equal(expected) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+
+	actual = runValidator(studentValidator, nil, &CMSInfo{Type: "Student"}, nil)
+	if !actual.equal(Problems{}) {
+		t.Errorf("expected no problems, got %+v", actual)
+	}
+}
+
+func TestProblemCodes(t *testing.T) {
+	type testCase struct {
+		expected []string
+		problems Problems
+	}
+	cases := []testCase{
+		testCase{expected: []string{}, problems: Problems{}},
+		testCase{expected: []string{"not_student"}, problems: studentValidator(nil, &CMSInfo{Type: "Employee"}, nil)},
+		testCase{expected: []string{"partial_rin_format", "rin_mismatch"}, problems: rinRCSMatchValidator(&nominationInfo{PartialRIN: "1234"}, &CMSInfo{RIN: "660000777"}, nil)},
+		testCase{
+			expected: []string{"no_graduation_date", "validator_error"},
+			problems: Problems{needsReviewWarning, validatorPanicProblem, problem(codeNoGraduationDate, "No graduation date on file; cohort inferred from entry date.")},
+		},
+		// problems read back from storage only have their messages
+		testCase{expected: []string{"cohort"}, problems: Problems{problem(codeCohort, "Cohorts not eligible for this office."), Problem{Message: "Not a student."}}},
+	}
+
+	for _, c := range cases {
+		actual := c.problems.codes()
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestProblemJSON(t *testing.T) {
+	problems := Problems{problem(codeNotStudent, "Not a student."), problem(codeRINMismatch, "Mismatched RIN digits.")}
+	data, err := json.Marshal(problems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `["Not a student.","Mismatched RIN digits."]`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	actual := Problems{}
+	err = json.Unmarshal(data, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != 2 || actual[0].Message != "Not a student." || actual[0].Code != "" {
+		t.Errorf("expected messages without codes, got %+v", actual)
+	}
+}
+
+func TestNominatorLimitValidator(t *testing.T) {
+	type testCase struct {
+		expected   Problems
+		limit      int
+		candidates []string
+	}
+	cases := []testCase{
+		testCase{expected: Problems{}, limit: 0, candidates: nil},
+		testCase{expected: Problems{}, limit: 2, candidates: []string{}},
+		testCase{expected: Problems{}, limit: 2, candidates: []string{"kochms"}},
+		testCase{expected: Problems{problem(codeNominatorLimit, "Nominator has already nominated the maximum number of candidates for this office (2): kochms, smithj.")}, limit: 2, candidates: []string{"kochms", "smithj"}},
+		testCase{expected: Problems{problem(codeNominatorLimit, "Nominator has already nominated the maximum number of candidates for this office (1): kochms, smithj.")}, limit: 1, candidates: []string{"kochms", "smithj"}},
+	}
+
+	for _, c := range cases {
+		queries := []fakeQuery{}
+		// offices without a limit don't need the database
+		if c.candidates != nil {
+			rows := [][]driver.Value{}
+			for _, candidate := range c.candidates {
+				rows = append(rows, []driver.Value{candidate})
+			}
+			queries = append(queries, fakeQuery{match: "SELECT DISTINCT rcs_id FROM nominations", columns: []string{"rcs_id"}, rows: rows})
+		}
+		_, done := useFakeDB(t, queries...)
+
+		nomination := &nominationInfo{ID: 9, RcsID: "doej", CandidateRCS: "lyonj4"}
+		office := &officeInfo{ID: 3, MaxCandidatesPerNominator: c.limit}
+		actual, err := nominatorLimitValidator(nomination, &CMSInfo{}, office)
+		done()
+		if err != nil {
+			t.Errorf("limit %d with %v: %s", c.limit, c.candidates, err.Error())
+			continue
+		}
+		if !actual.equal(c.expected) {
+			t.Errorf("limit %d with %v: expected %v, got %v", c.limit, c.candidates, c.expected, actual)
+		}
+	}
+}
+
+func TestOfficeNominatorLimit(t *testing.T) {
+	type testCase struct {
+		expected int
+		rows     [][]driver.Value
+	}
+	cases := []testCase{
+		testCase{expected: 3, rows: [][]driver.Value{[]driver.Value{int64(3)}}},
+		testCase{expected: 0, rows: [][]driver.Value{[]driver.Value{nil}}},
+		testCase{expected: 0, rows: [][]driver.Value{}},
+	}
+
+	for _, c := range cases {
+		_, done := useFakeDB(t, fakeQuery{match: "FROM office_rules", columns: []string{"max_candidates_per_nominator"}, rows: c.rows})
+		db, err := getDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := officeNominatorLimit(db, 3)
+		db.Close()
+		done()
+		if err != nil || actual != c.expected {
+			t.Errorf("%v: expected %d, got %d (%v)", c.rows, c.expected, actual, err)
+		}
+	}
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhook events
+const (
+	webhookPageSubmitted         = "page.submitted"
+	webhookNominationValidated   = "nomination.validated"
+	webhookNominationInvalidated = "nomination.invalidated"
+	webhookCandidateQualified    = "candidate.qualified"
+)
+
+var allWebhookEvents = []string{webhookPageSubmitted, webhookNominationValidated, webhookNominationInvalidated, webhookCandidateQualified}
+
+// webhookMaxAttempts is how many times a delivery is tried before giving up. The delay between
+// attempts starts at webhookRetryDelay and doubles after each one.
+const webhookMaxAttempts = 5
+
+var webhookRetryDelay = 10 * time.Second
+
+// webhookPollInterval is how often the delivery worker looks for deliveries that are due.
+var webhookPollInterval = 5 * time.Second
+
+// webhookLease is how long a delivery is left alone once a worker has picked it up. It must be longer
+// than webhookClient's timeout, so a delivery is only ever attempted by one worker at a time.
+const webhookLease = time.Minute
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhook is an outbound webhook. The secret is only included when it is created. Secrets are
+// stored as they are, unlike API tokens, because signing a payload takes the secret itself rather
+// than something derived from it; anyone who can read the webhooks table can forge deliveries.
+type webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+// webhookDelivery is an entry in the delivery log.
+type webhookDelivery struct {
+	ID            int             `json:"id"`
+	WebhookID     int             `json:"webhook_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	StatusCode    *int            `json:"status_code"`
+	Error         *string         `json:"error"`
+	Delivered     bool            `json:"delivered"`
+	CreatedAt     time.Time       `json:"created_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at"`
+}
+
+// webhookPayload is the body of a webhook request.
+type webhookPayload struct {
+	Event string    `json:"event"`
+	Sent  time.Time `json:"sent"`
+	Data  event     `json:"data"`
+}
+
+// parseWebhookEvents checks a list of webhook events, returning false if any are unknown.
+func parseWebhookEvents(names []string) ([]string, bool) {
+	events := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !contains(allWebhookEvents, name) {
+			return events, false
+		}
+		events = append(events, name)
+	}
+	return events, true
+}
+
+// validWebhookURL returns whether a URL can be used for a webhook: it must be absolute http or https.
+func validWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// webhookEvents returns the webhook events for an event. Validity only counts when an admin changes
+// it, not when the validator checks a nomination. A candidate qualifies when a nomination being
+// marked valid takes her valid count from previousCount, below the number the office requires, to
+// at least that number.
+func webhookEvents(e event, previousCount int, required int) []string {
+	switch e.Type {
+	case eventPageSubmitted:
+		return []string{webhookPageSubmitted}
+	case eventNominationModified:
+		if !e.ValidChanged || e.Valid == nil {
+			return []string{}
+		}
+		if !*e.Valid {
+			return []string{webhookNominationInvalidated}
+		}
+		if required > 0 && previousCount < required && e.ValidCount >= required {
+			return []string{webhookNominationValidated, webhookCandidateQualified}
+		}
+		return []string{webhookNominationValidated}
+	}
+	return []string{}
+}
+
+// signWebhook returns the signature of a payload, sent in the X-Elecnoms-Signature header so
+// receivers can check it came from us: "sha256=" followed by the hex-encoded HMAC-SHA256 of the
+// body, keyed with the webhook's secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhook makes one attempt at a delivery, returning the response status code. Anything but a
+// 2xx response is an error.
+func sendWebhook(hookURL string, secret string, eventName string, deliveryID int, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hookURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Elecnoms-Event", eventName)
+	req.Header.Set("X-Elecnoms-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Elecnoms-Signature", signWebhook(secret, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// queueWebhooks queues a delivery of each webhook event for an event to every active webhook
+// subscribed to it. It's called in the transaction that makes the change, so deliveries are queued
+// if and only if the change is stored. previousCount is the candidate's valid count before the change.
+func queueWebhooks(tx *sql.Tx, e event, previousCount int) error {
+	required := 0
+	if e.Type == eventNominationModified {
+		row := tx.QueryRow("SELECT nominations_required FROM offices WHERE office_id = ?", e.OfficeID)
+		err := row.Scan(&required)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	for _, name := range webhookEvents(e, previousCount, required) {
+		body, err := json.Marshal(webhookPayload{Event: name, Sent: time.Now(), Data: e})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at) SELECT webhook_id, ?, ?, NOW() FROM webhooks WHERE active = true AND FIND_IN_SET(?, events) > 0", name, body, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queuePageWebhooks queues the webhooks for a newly submitted page.
+func queuePageWebhooks(tx *sql.Tx, rcs string, office string, page int) error {
+	e, err := pageEvent(tx, rcs, office, page)
+	if err != nil {
+		return err
+	}
+	return queueWebhooks(tx, e, e.ValidCount)
+}
+
+// queueNominationWebhooks queues the webhooks for a change to a nomination. If the change made the
+// nomination valid or invalid, previousCount must come from lockValidCount before the change.
+func queueNominationWebhooks(tx *sql.Tx, nominationID int, validChanged bool, previousCount int) error {
+	e, err := nominationEvent(tx, eventNominationModified, nominationID, validChanged)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if validChanged {
+		// count again with the lock, rather than from a snapshot that may be missing other changes
+		e.ValidCount, err = lockValidCount(tx, nominationID)
+		if err != nil {
+			return err
+		}
+	}
+	return queueWebhooks(tx, e, previousCount)
+}
+
+// queuedDelivery is a pending delivery, along with the webhook it's going to.
+type queuedDelivery struct {
+	id       int
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// startWebhooks starts a worker that makes delivery attempts as they come due, until the program
+// exits. Deliveries are kept in the database, so ones still pending when the program stopped are
+// picked up again when it starts.
+func startWebhooks() {
+	go func() {
+		for {
+			err := deliverDueWebhooks()
+			if err != nil {
+				log.Printf("unable to deliver webhooks: %s", err.Error())
+			}
+			time.Sleep(webhookPollInterval)
+		}
+	}()
+}
+
+// deliverDueWebhooks makes an attempt at each pending delivery that is due, at the same time, and
+// waits for them to finish. Deliveries to inactive webhooks wait until they're active again.
+func deliverDueWebhooks() error {
+	db, err := getDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT d.delivery_id, d.event, d.payload, d.attempts, h.url, h.secret FROM webhook_deliveries d JOIN webhooks h ON h.webhook_id = d.webhook_id WHERE d.delivered = false AND d.next_attempt_at <= NOW() AND h.active = true ORDER BY d.next_attempt_at LIMIT 100")
+	if err != nil {
+		return err
+	}
+	deliveries := []queuedDelivery{}
+	for rows.Next() {
+		d := queuedDelivery{}
+		err = rows.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		// take the delivery, unless another worker got to it first
+		res, err := db.Exec("UPDATE webhook_deliveries SET next_attempt_at = NOW() + INTERVAL ? SECOND WHERE delivery_id = ? AND delivered = false AND next_attempt_at <= NOW()", int(webhookLease.Seconds()), d.id)
+		if err != nil {
+			wg.Wait()
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(d queuedDelivery) {
+			defer wg.Done()
+			attemptWebhook(db, d)
+		}(d)
+	}
+	wg.Wait()
+	return nil
+}
+
+// webhookBackoff returns how long to wait after a failed attempt before the next one.
+func webhookBackoff(attempt int) time.Duration {
+	return webhookRetryDelay << uint(attempt-1)
+}
+
+// attemptWebhook makes one attempt at a delivery and records it in the delivery log, scheduling the
+// next attempt if it failed and there are attempts left.
+func attemptWebhook(ex execer, d queuedDelivery) {
+	attempt := d.attempts + 1
+	code, err := sendWebhook(d.url, d.secret, d.event, d.id, d.payload)
+
+	statusCode := sql.NullInt64{Int64: int64(code), Valid: code != 0}
+	errorMessage := sql.NullString{}
+	if err != nil {
+		errorMessage = sql.NullString{String: err.Error(), Valid: true}
+		log.Printf("webhook delivery %d to %s failed (attempt %d): %s", d.id, d.url, attempt, err.Error())
+	}
+
+	var dbErr error
+	if err != nil && attempt < webhookMaxAttempts {
+		_, dbErr = ex.Exec("UPDATE webhook_deliveries SET attempts = ?, status_code = ?, error = ?, last_attempt_at = NOW(), next_attempt_at = NOW() + INTERVAL ? SECOND WHERE delivery_id = ?", attempt, statusCode, errorMessage, int(webhookBackoff(attempt).Seconds()), d.id)
+	} else {
+		_, dbErr = ex.Exec("UPDATE webhook_deliveries SET attempts = ?, status_code = ?, error = ?, delivered = ?, last_attempt_at = NOW(), next_attempt_at = NULL WHERE delivery_id = ?", attempt, statusCode, errorMessage, err == nil, d.id)
+	}
+	if dbErr != nil {
+		log.Printf("unable to record webhook delivery %d: %s", d.id, dbErr.Error())
+	}
+}
+
+// createWebhook adds a webhook. The body is a JSON object with a url and a list of events. A secret
+// for signing payloads is generated, and only ever returned in this response.
+// Requires authorization, and only admins logged in with a session can use it.
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	req := struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}{}
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&req)
+	if err != nil {
+		log.Printf("unable to decode JSON: %s", err.Error())
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+	if !validWebhookURL(req.URL) {
+		writeError(w, r, http.StatusUnprocessableEntity, "url", "invalid url")
+		return
+	}
+	events, ok := parseWebhookEvents(req.Events)
+	if !ok || len(events) == 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, "events", "invalid events")
+		return
+	}
+
+	// generate secret
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		log.Printf("unable to generate secret: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	casUser := casUserFromContext(r.Context())
+	res, err := db.Exec("INSERT INTO webhooks (url, secret, events, created_by) VALUES (?, ?, ?, ?)", req.URL, secret, strings.Join(events, ","), casUser)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("unable to get webhook ID: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	resp := webhook{
+		ID:        int(id),
+		URL:       req.URL,
+		Events:    events,
+		Active:    true,
+		CreatedBy: casUser,
+		CreatedAt: time.Now(),
+		Secret:    secret,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	enc.Encode(resp)
+}
+
+// listWebhooks returns every webhook, without their secrets.
+// Requires authorization, and only admins logged in with a session can use it.
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT webhook_id, url, events, active, created_by, created_at FROM webhooks ORDER BY webhook_id")
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	hooks := []webhook{}
+	for rows.Next() {
+		hook := webhook{}
+		var events string
+		err = rows.Scan(&hook.ID, &hook.URL, &events, &hook.Active, &hook.CreatedBy, &hook.CreatedAt)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		hook.Events, _ = parseWebhookEvents(strings.Split(events, ","))
+		hooks = append(hooks, hook)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(hooks)
+}
+
+// updateWebhook changes the webhook with the given ID. The body is a JSON object with any of url,
+// events and active; fields that are left out aren't changed.
+// Requires authorization, and only admins logged in with a session can use it.
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	hookID := r.FormValue("id")
+	if hookID == "" {
+		writeMissing(w, r, "id", "missing webhook ID")
+		return
+	}
+
+	req := struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}{}
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(&req)
+	if err != nil {
+		log.Printf("unable to decode JSON: %s", err.Error())
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	if req.URL != nil {
+		if !validWebhookURL(*req.URL) {
+			writeError(w, r, http.StatusUnprocessableEntity, "url", "invalid url")
+			return
+		}
+		sets = append(sets, "url = ?")
+		args = append(args, *req.URL)
+	}
+	if req.Events != nil {
+		events, ok := parseWebhookEvents(req.Events)
+		if !ok || len(events) == 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, "events", "invalid events")
+			return
+		}
+		sets = append(sets, "events = ?")
+		args = append(args, strings.Join(events, ","))
+	}
+	if req.Active != nil {
+		sets = append(sets, "active = ?")
+		args = append(args, *req.Active)
+	}
+	if len(sets) == 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, "", "nothing to update")
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM webhooks WHERE webhook_id = ?)", hookID).Scan(&exists)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+
+	args = append(args, hookID)
+	_, err = db.Exec("UPDATE webhooks SET "+strings.Join(sets, ", ")+" WHERE webhook_id = ?", args...)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteWebhook removes the webhook with the given ID, along with its delivery log, so pending
+// deliveries to it are dropped.
+// Requires authorization, and only admins logged in with a session can use it.
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	hookID := r.FormValue("id")
+	if hookID == "" {
+		writeMissing(w, r, "id", "missing webhook ID")
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("unable to begin transaction: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM webhooks WHERE webhook_id = ?", hookID)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+	_, err = tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", hookID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveries returns the most recent deliveries to a webhook, newest first.
+// Requires authorization, and only admins logged in with a session can use it.
+func listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !sessionAdmin(r.Context()) {
+		writeStatus(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	hookID := r.FormValue("webhook")
+	if hookID == "" {
+		writeMissing(w, r, "webhook", "missing webhook ID")
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		log.Printf("unable to get database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT delivery_id, webhook_id, event, payload, attempts, status_code, error, delivered, created_at, last_attempt_at, next_attempt_at FROM webhook_deliveries WHERE webhook_id = ? ORDER BY delivery_id DESC LIMIT 100", hookID)
+	if err != nil {
+		log.Printf("unable to query database: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []webhookDelivery{}
+	for rows.Next() {
+		delivery := webhookDelivery{}
+		var payload string
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Attempts, &delivery.StatusCode, &delivery.Error, &delivery.Delivered, &delivery.CreatedAt, &delivery.LastAttemptAt, &delivery.NextAttemptAt)
+		if err != nil {
+			log.Printf("unable to scan: %s", err.Error())
+			writeStatus(w, r, http.StatusInternalServerError)
+			return
+		}
+		delivery.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("unable to read rows: %s", err.Error())
+		writeStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(deliveries)
+}
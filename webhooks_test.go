@@ -0,0 +1,251 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookEvents(t *testing.T) {
+	valid := true
+	invalid := false
+	type testCase struct {
+		expected []string
+		e        event
+		previous int
+		required int
+	}
+	cases := []testCase{
+		testCase{expected: []string{webhookPageSubmitted}, e: event{Type: eventPageSubmitted}, required: 50},
+		testCase{expected: []string{webhookNominationValidated}, e: event{Type: eventNominationModified, Valid: &valid, ValidChanged: true, ValidCount: 49}, previous: 48, required: 50},
+		testCase{expected: []string{webhookNominationValidated, webhookCandidateQualified}, e: event{Type: eventNominationModified, Valid: &valid, ValidChanged: true, ValidCount: 50}, previous: 49, required: 50},
+		testCase{expected: []string{webhookNominationValidated}, e: event{Type: eventNominationModified, Valid: &valid, ValidChanged: true, ValidCount: 51}, previous: 50, required: 50},
+		// other nominations were marked valid at the same time, so the count skipped past the requirement
+		testCase{expected: []string{webhookNominationValidated, webhookCandidateQualified}, e: event{Type: eventNominationModified, Valid: &valid, ValidChanged: true, ValidCount: 51}, previous: 49, required: 50},
+		testCase{expected: []string{webhookNominationValidated}, e: event{Type: eventNominationModified, Valid: &valid, ValidChanged: true, ValidCount: 50}, previous: 50, required: 50},
+		testCase{expected: []string{webhookNominationValidated}, e: event{Type: eventNominationModified, Valid: &valid, ValidChanged: true, ValidCount: 50}, previous: 49},
+		testCase{expected: []string{webhookNominationInvalidated}, e: event{Type: eventNominationModified, Valid: &invalid, ValidChanged: true, ValidCount: 49}, previous: 50, required: 50},
+		testCase{expected: []string{}, e: event{Type: eventNominationModified, Valid: &valid, ValidChanged: false, ValidCount: 50}, previous: 49, required: 50},
+		testCase{expected: []string{}, e: event{Type: eventNominationModified, ValidChanged: true}, required: 50},
+		testCase{expected: []string{}, e: event{Type: eventNominationChecked, Valid: &invalid}, required: 50},
+	}
+
+	for _, c := range cases {
+		actual := webhookEvents(c.e, c.previous, c.required)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("expected %v for %+v from %d, got %v", c.expected, c.e, c.previous, actual)
+		}
+	}
+}
+
+func TestQueueNominationWebhooks(t *testing.T) {
+	db, done := useFakeDB(t,
+		fakeQuery{match: "SELECT rcs_id, office_id, page, valid FROM nominations", columns: []string{"rcs_id", "office_id", "page", "valid"}, rows: [][]driver.Value{[]driver.Value{"LYONJ4", int64(3), int64(1), int64(1)}}},
+		fakeQuery{match: "SELECT COUNT(*) FROM nominations", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(49)}}},
+		fakeQuery{match: "SUM(n.valid = true)", columns: []string{"count"}, rows: [][]driver.Value{[]driver.Value{int64(50)}}},
+		fakeQuery{match: "SELECT nominations_required FROM offices", columns: []string{"nominations_required"}, rows: [][]driver.Value{[]driver.Value{int64(50)}}},
+		fakeQuery{match: "INSERT INTO webhook_deliveries", affected: 2},
+		fakeQuery{match: "INSERT INTO webhook_deliveries", affected: 1},
+	)
+	defer done()
+
+	conn, err := getDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// the snapshot count is stale, but the locked count brings the candidate up to the requirement
+	err = queueNominationWebhooks(tx, 7, true, 49)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := db.called("INSERT INTO webhook_deliveries")
+	if len(calls) != 2 || calls[0].args[0] != webhookNominationValidated || calls[1].args[0] != webhookCandidateQualified {
+		t.Fatalf("expected validated and qualified deliveries, got %+v", calls)
+	}
+	payload := webhookPayload{}
+	err = json.Unmarshal(calls[1].args[1].([]byte), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != webhookCandidateQualified || payload.Data.CandidateRCS != "lyonj4" || payload.Data.ValidCount != 50 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestDeliverDueWebhooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Elecnoms-Delivery") == "1" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	claim := "SET next_attempt_at = NOW() + INTERVAL ? SECOND WHERE delivery_id = ? AND delivered = false"
+	record := "SET attempts = ?"
+	db, done := useFakeDB(t,
+		fakeQuery{match: "FROM webhook_deliveries d JOIN webhooks h", columns: []string{"delivery_id", "event", "payload", "attempts", "url", "secret"}, rows: [][]driver.Value{
+			// delivered on the first attempt
+			[]driver.Value{int64(1), webhookPageSubmitted, []byte(`{}`), int64(0), server.URL, "secret"},
+			// left over from before a restart, and fails again
+			[]driver.Value{int64(2), webhookPageSubmitted, []byte(`{}`), int64(2), server.URL, "secret"},
+			// fails for the last time
+			[]driver.Value{int64(3), webhookPageSubmitted, []byte(`{}`), int64(webhookMaxAttempts - 1), server.URL, "secret"},
+			// already taken by another worker
+			[]driver.Value{int64(4), webhookPageSubmitted, []byte(`{}`), int64(0), server.URL, "secret"},
+		}},
+		fakeQuery{match: claim, affected: 1},
+		fakeQuery{match: claim, affected: 1},
+		fakeQuery{match: claim, affected: 1},
+		fakeQuery{match: claim, affected: 0},
+		fakeQuery{match: record, affected: 1},
+		fakeQuery{match: record, affected: 1},
+		fakeQuery{match: record, affected: 1},
+	)
+	defer done()
+
+	err := deliverDueWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := map[int64]fakeCall{}
+	for _, call := range db.called(record) {
+		recorded[call.args[len(call.args)-1].(int64)] = call
+	}
+	if len(recorded) != 3 {
+		t.Fatalf("expected 3 attempts recorded, got %+v", recorded)
+	}
+	if call := recorded[1]; !strings.Contains(call.query, "next_attempt_at = NULL") || call.args[0] != int64(1) || call.args[3] != true {
+		t.Errorf("expected delivery 1 to be delivered, got %+v", call)
+	}
+	if call := recorded[2]; !strings.Contains(call.query, "INTERVAL") || call.args[0] != int64(3) || call.args[3] != int64(webhookBackoff(3).Seconds()) {
+		t.Errorf("expected delivery 2 to be retried, got %+v", call)
+	}
+	if call := recorded[3]; !strings.Contains(call.query, "next_attempt_at = NULL") || call.args[0] != int64(webhookMaxAttempts) || call.args[3] != false {
+		t.Errorf("expected delivery 3 to be given up on, got %+v", call)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	expected := []time.Duration{webhookRetryDelay, 2 * webhookRetryDelay, 4 * webhookRetryDelay, 8 * webhookRetryDelay}
+	for i, delay := range expected {
+		if actual := webhookBackoff(i + 1); actual != delay {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, delay, actual)
+		}
+	}
+}
+
+func TestParseWebhookEvents(t *testing.T) {
+	events, ok := parseWebhookEvents([]string{"page.submitted", " candidate.qualified", ""})
+	if !ok || !reflect.DeepEqual(events, []string{webhookPageSubmitted, webhookCandidateQualified}) {
+		t.Errorf("unexpected result %v (%t)", events, ok)
+	}
+	if _, ok = parseWebhookEvents([]string{"page.submitted", "nomination.deleted"}); ok {
+		t.Error("expected unknown event to be rejected")
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	body := []byte(`{"event":"page.submitted"}`)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := ioutil.ReadAll(r.Body)
+		if string(received) != string(body) {
+			t.Errorf("expected body %s, got %s", body, received)
+		}
+		if sig := r.Header.Get("X-Elecnoms-Signature"); sig != signWebhook("secret", body) {
+			t.Errorf("unexpected signature %q", sig)
+		}
+		if r.Header.Get("X-Elecnoms-Event") != "page.submitted" || r.Header.Get("X-Elecnoms-Delivery") != "12" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	code, err := sendWebhook(server.URL, "secret", "page.submitted", 12, body)
+	if err != nil || code != http.StatusOK {
+		t.Errorf("expected success, got %d, %v", code, err)
+	}
+
+	status = http.StatusServiceUnavailable
+	code, err = sendWebhook(server.URL, "secret", "page.submitted", 12, body)
+	if err == nil || code != http.StatusServiceUnavailable {
+		t.Errorf("expected failure with 503, got %d, %v", code, err)
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// HMAC-SHA256 test vector from RFC 4231, test case 2
+	expected := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if actual := signWebhook("Jefe", []byte("what do ya want for nothing?")); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestValidWebhookURL(t *testing.T) {
+	for s, expected := range map[string]bool{
+		"https://hooks.slack.com/services/T/B/X": true,
+		"http://localhost:3000/hooks":            true,
+		"ftp://example.com/":                     false,
+		"/relative":                              false,
+		"":                                       false,
+	} {
+		if actual := validWebhookURL(s); actual != expected {
+			t.Errorf("expected %t for %q, got %t", expected, s, actual)
+		}
+	}
+}
+
+func TestListWebhooksRowErrors(t *testing.T) {
+	created := time.Date(2019, time.February, 1, 12, 0, 0, 0, time.UTC)
+	lost := errors.New("connection lost")
+
+	type testCase struct {
+		handler http.HandlerFunc
+		target  string
+		query   fakeQuery
+	}
+	cases := []testCase{
+		testCase{
+			handler: listWebhooks, target: "/webhooks",
+			query: fakeQuery{match: "FROM webhooks ORDER BY", columns: []string{"webhook_id", "url", "events", "active", "created_by", "created_at"}, rows: [][]driver.Value{
+				[]driver.Value{int64(1), "https://example.com/hook", "page.submitted", int64(1), "admin1", created},
+			}, rowsErr: lost},
+		},
+		testCase{
+			handler: listWebhookDeliveries, target: "/webhooks/deliveries?webhook=1",
+			query: fakeQuery{match: "FROM webhook_deliveries WHERE webhook_id = ?", columns: []string{"delivery_id", "webhook_id", "event", "payload", "attempts", "status_code", "error", "delivered", "created_at", "last_attempt_at", "next_attempt_at"}, rows: [][]driver.Value{
+				[]driver.Value{int64(1), int64(1), "page.submitted", []byte(`{}`), int64(1), int64(200), nil, int64(1), created, created, nil},
+			}, rowsErr: lost},
+		},
+	}
+
+	for _, c := range cases {
+		_, done := useFakeDB(t, c.query)
+		w := httptest.NewRecorder()
+		c.handler(w, httptest.NewRequest(http.MethodGet, c.target, nil).WithContext(userContext("admin1", true)))
+		done()
+
+		// a partial list isn't passed off as the whole thing
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: expected status 500, got %d: %s", c.target, w.Code, w.Body.String())
+		}
+	}
+}